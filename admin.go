@@ -0,0 +1,177 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type roleRow struct {
+	Name        string
+	Permissions map[string]bool
+}
+
+func (s *Server) adminRolesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Retrieve the role and its selected permissions
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		role := r.Form.Get("role")
+		if !validRoleName(role) {
+			http.Error(w, "Invalid role name", http.StatusBadRequest)
+			return
+		}
+
+		var perms []string
+		for _, perm := range r.Form["permission"] {
+			if !isKnownPermission(perm) {
+				http.Error(w, "Unknown permission", http.StatusBadRequest)
+				return
+			}
+			perms = append(perms, perm)
+		}
+
+		// Someone must be left to manage the roles
+		ok, err := s.leavesUserManager(
+			func(u User) string { return u.Role },
+			func(r string) bool {
+				if r == role {
+					return containsString(perms, PermUsersManage)
+				}
+				return s.perms.has(r, PermUsersManage)
+			},
+		)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, noUserManagerMessage, http.StatusConflict)
+			return
+		}
+
+		// Save the bundle and refresh the cache
+		if err := s.perms.save(role, perms); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		return
+	}
+
+	users, err := s.users.List()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var roles []roleRow
+	for _, name := range s.perms.roleNames() {
+		row := roleRow{Name: name, Permissions: make(map[string]bool)}
+		for _, perm := range allPermissions {
+			row.Permissions[perm] = s.perms.has(name, perm)
+		}
+		roles = append(roles, row)
+	}
+
+	data := struct {
+		Roles       []roleRow
+		Permissions []string
+		Users       []User
+	}{
+		Roles:       roles,
+		Permissions: allPermissions,
+		Users:       users,
+	}
+
+	// Render the role management page
+	tpl, err := template.ParseFiles("templates/roles.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) adminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	role := r.FormValue("role")
+	if !s.perms.exists(role) {
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+
+	// Someone must be left to manage the roles
+	ok, err := s.leavesUserManager(
+		func(u User) string {
+			if u.ID == userID {
+				return role
+			}
+			return u.Role
+		},
+		func(r string) bool { return s.perms.has(r, PermUsersManage) },
+	)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, noUserManagerMessage, http.StatusConflict)
+		return
+	}
+
+	err = s.users.UpdateRole(userID, role)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Sessions carry the role they were issued with, so sign the user out
+	if err := s.sessions.DeleteUser(userID); err != nil {
+		log.Println(err)
+	}
+
+	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+}
+
+const noUserManagerMessage = "At least one user must keep the users.manage permission"
+
+// leavesUserManager reports whether some user holds users.manage after a
+// change, given the role each user will have and whether a role will grant
+// the permission.
+func (s *Server) leavesUserManager(roleOf func(User) string, grants func(role string) bool) (bool, error) {
+	users, err := s.users.List()
+	if err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if grants(roleOf(u)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forms screened for spam
+const (
+	SpamFormContact  = "contact"
+	SpamFormRegister = "register"
+)
+
+var spamForms = []string{SpamFormContact, SpamFormRegister}
+
+// Form fields added by the spam guard. The honeypot is hidden from people,
+// so only bots fill it in.
+const (
+	spamTokenField    = "form_token"
+	spamHoneypotField = "website"
+)
+
+// spamTokenMaxAge is how long a rendered form can be submitted.
+const spamTokenMaxAge = 24 * time.Hour
+
+// SpamSubmission is a public form submission being screened.
+type SpamSubmission struct {
+	Form string
+	IP   string
+	// Fields holds the submitted values shown to reviewers. Passwords are
+	// never included.
+	Fields map[string]string
+}
+
+// SpamCheck scores a submission. It returns 0 for submissions it has no
+// opinion on, and a reason for the reviewers otherwise.
+type SpamCheck func(sub *SpamSubmission) (score int, reason string)
+
+// spamVerdict is the outcome of screening a submission.
+type spamVerdict struct {
+	Score   int
+	Reasons []string
+	// Limited is set when the client sent too many submissions.
+	Limited bool
+}
+
+func (v *spamVerdict) add(score int, reason string) {
+	if score == 0 {
+		return
+	}
+	v.Score += score
+	v.Reasons = append(v.Reasons, reason)
+}
+
+// spamGuard screens the public forms. The honeypot, the form token and the
+// rate limit reject a submission on their own; the heuristics and the
+// added checks add up to a score, rejected from threshold on.
+type spamGuard struct {
+	secret []byte
+	// minAge is the least time a person takes to fill in a form.
+	minAge    time.Duration
+	limiter   *rateLimiter
+	threshold int
+	checks    []SpamCheck
+}
+
+// newSpamGuard configures the spam guard from the environment.
+func newSpamGuard() (*spamGuard, error) {
+	secret := []byte(getEnv("SPAM_SECRET", ""))
+	if len(secret) == 0 {
+		// Without a shared secret, forms rendered before a restart or by
+		// another instance are rejected
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	minAge, err := time.ParseDuration(getEnv("SPAM_MIN_SUBMIT_TIME", "3s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPAM_MIN_SUBMIT_TIME: %v", err)
+	}
+	window, err := time.ParseDuration(getEnv("SPAM_RATE_WINDOW", "10m"))
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid SPAM_RATE_WINDOW %q", getEnv("SPAM_RATE_WINDOW", "10m"))
+	}
+	limit, err := envInt("SPAM_RATE_LIMIT", 5)
+	if err != nil {
+		return nil, err
+	}
+	maxLinks, err := envInt("SPAM_MAX_LINKS", 2)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := envInt("SPAM_THRESHOLD", 5)
+	if err != nil {
+		return nil, err
+	}
+	// A threshold of 0 would reject every submission
+	if threshold < 1 {
+		return nil, fmt.Errorf("invalid SPAM_THRESHOLD %q: use at least 1", getEnv("SPAM_THRESHOLD", ""))
+	}
+
+	g := &spamGuard{
+		secret:    secret,
+		minAge:    minAge,
+		limiter:   newRateLimiter(limit, window),
+		threshold: threshold,
+	}
+	g.addCheck(linkCheck(maxLinks))
+	if keywords := splitList(getEnv("SPAM_KEYWORDS", "")); len(keywords) > 0 {
+		g.addCheck(keywordCheck(keywords))
+	}
+	return g, nil
+}
+
+func envInt(key string, defaultValue int) (int, error) {
+	v := getEnv(key, "")
+	if v == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return n, nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// addCheck adds a check to the score of every submission.
+func (g *spamGuard) addCheck(check SpamCheck) {
+	g.checks = append(g.checks, check)
+}
+
+// token returns the form token for a form rendered at now. It signs the
+// render time, so the submission can be timed.
+func (g *spamGuard) token(form string, now time.Time) string {
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+	return ts + "." + g.sign(form, ts)
+}
+
+func (g *spamGuard) sign(form, ts string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(form + ":" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkToken returns why the token is not acceptable at now, or "".
+func (g *spamGuard) checkToken(form, token string, now time.Time) string {
+	ts, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(g.sign(form, ts))) {
+		return "missing or forged form token"
+	}
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "missing or forged form token"
+	}
+	age := now.Sub(time.UnixMilli(ms))
+	switch {
+	case age < g.minAge:
+		return fmt.Sprintf("sent %s after the form was shown", age.Round(100*time.Millisecond))
+	case age > spamTokenMaxAge:
+		return "form token expired"
+	}
+	return ""
+}
+
+// screen judges the submission made with the request's form values.
+func (g *spamGuard) screen(sub *SpamSubmission, r *http.Request, now time.Time) *spamVerdict {
+	v := &spamVerdict{}
+	if !g.limiter.allow(sub.Form+" "+sub.IP, now) {
+		v.Limited = true
+		v.add(g.threshold, "rate limited")
+	}
+	if r.FormValue(spamHoneypotField) != "" {
+		v.add(g.threshold, "honeypot field filled in")
+	}
+	if reason := g.checkToken(sub.Form, r.FormValue(spamTokenField), now); reason != "" {
+		v.add(g.threshold, reason)
+	}
+	for _, check := range g.checks {
+		v.add(check(sub))
+	}
+	return v
+}
+
+// rejects reports whether the verdict keeps the submission out.
+func (g *spamGuard) rejects(v *spamVerdict) bool {
+	return v.Score >= g.threshold
+}
+
+// linkCheck scores submissions carrying more than max links.
+func linkCheck(max int) SpamCheck {
+	return func(sub *SpamSubmission) (int, string) {
+		links := 0
+		for _, v := range sub.Fields {
+			v = strings.ToLower(v)
+			links += strings.Count(v, "http://") + strings.Count(v, "https://") + strings.Count(v, "www.")
+		}
+		if links <= max {
+			return 0, ""
+		}
+		return 5, fmt.Sprintf("%d links", links)
+	}
+}
+
+// keywordCheck scores submissions for every keyword they contain.
+func keywordCheck(keywords []string) SpamCheck {
+	return func(sub *SpamSubmission) (int, string) {
+		var found []string
+		for _, kw := range keywords {
+			for _, v := range sub.Fields {
+				if strings.Contains(strings.ToLower(v), strings.ToLower(kw)) {
+					found = append(found, strconv.Quote(kw))
+					break
+				}
+			}
+		}
+		if len(found) == 0 {
+			return 0, ""
+		}
+		return 3 * len(found), "keywords " + strings.Join(found, ", ")
+	}
+}
+
+// rateLimiter allows each key a number of events per sliding window.
+type rateLimiter struct {
+	limit     int
+	window    time.Duration
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// allow reports whether the key may have another event now, and records
+// it if so. A limit of 0 allows everything.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if l.limit == 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the keys without recent events now and then
+	if now.Sub(l.lastSweep) > l.window {
+		for k, events := range l.events {
+			if now.Sub(events[len(events)-1]) > l.window {
+				delete(l.events, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	events := l.events[key]
+	for len(events) > 0 && now.Sub(events[0]) > l.window {
+		events = events[1:]
+	}
+	// Refused events are not recorded, so a flood cannot grow the log
+	if len(events) >= l.limit {
+		l.events[key] = events
+		return false
+	}
+	l.events[key] = append(events, now)
+	return true
+}
+
+// spamForm is the data the public forms render with.
+type spamForm struct {
+	Token string
+}
+
+// spamFormData returns what a public form needs to pass the spam guard.
+func (s *Server) spamFormData(form string) spamForm {
+	return spamForm{Token: s.spam.token(form, time.Now())}
+}
+
+// publicForm is the data the public forms render with: the spam guard's
+// token and, after a failed submission, the values sent and their errors.
+type publicForm struct {
+	spamForm
+	formState
+}
+
+// screenSubmission runs the spam guard on a public form submission. When it
+// rejects the submission, it records it for review, answers the request and
+// returns false.
+func (s *Server) screenSubmission(w http.ResponseWriter, r *http.Request, sub *SpamSubmission) bool {
+	sub.IP = clientIP(r)
+	now := time.Now().UTC()
+	v := s.spam.screen(sub, r, now)
+	if !s.spam.rejects(v) {
+		return true
+	}
+	log.Printf("spam: rejected %s submission from %s (score %d: %s)", sub.Form, sub.IP, v.Score, strings.Join(v.Reasons, "; "))
+
+	// A flood is only logged, so it cannot fill the spam queue
+	if v.Limited {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.spam.limiter.window.Seconds())))
+		http.Error(w, "Too many submissions, please try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	report := &SpamReport{Form: sub.Form, IP: sub.IP, Fields: sub.Fields, Score: v.Score, Reasons: v.Reasons, CreatedAt: now}
+	if _, err := s.spamReports.Add(report); err != nil {
+		log.Println(err)
+	}
+	http.Error(w, "Your submission could not be accepted. Please reload the page and try again.", http.StatusBadRequest)
+	return false
+}
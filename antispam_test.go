@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckToken(t *testing.T) {
+	g := &spamGuard{secret: []byte("secret"), minAge: 3 * time.Second}
+	shown := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	token := g.token(SpamFormContact, shown)
+	ts, _, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		form  string
+		token string
+		at    time.Time
+		want  string
+	}{
+		{"in time", SpamFormContact, token, shown.Add(time.Minute), ""},
+		{"too fast", SpamFormContact, token, shown.Add(time.Second), "sent 1s after the form was shown"},
+		{"expired", SpamFormContact, token, shown.Add(spamTokenMaxAge + time.Second), "form token expired"},
+		{"other form", SpamFormRegister, token, shown.Add(time.Minute), "missing or forged form token"},
+		{"forged", SpamFormContact, ts + ".00", shown.Add(time.Minute), "missing or forged form token"},
+		{"missing", SpamFormContact, "", shown.Add(time.Minute), "missing or forged form token"},
+	}
+	for _, tt := range tests {
+		if got := g.checkToken(tt.form, tt.token, tt.at); got != tt.want {
+			t.Errorf("%s: checkToken = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		key   string
+		after time.Duration
+		want  bool
+	}{
+		{"a", 0, true},
+		{"a", time.Second, true},
+		{"a", 2 * time.Second, false},
+		{"b", 2 * time.Second, true},
+		// Refused events do not count, so the window ends a minute after
+		// the first accepted one
+		{"a", time.Minute + time.Second, true},
+		{"a", time.Minute + 2*time.Second, true},
+		{"a", time.Minute + 3*time.Second, false},
+	}
+	for i, st := range steps {
+		if got := l.allow(st.key, start.Add(st.after)); got != st.want {
+			t.Errorf("step %d: allow(%q) = %v, want %v", i, st.key, got, st.want)
+		}
+	}
+
+	if unlimited := newRateLimiter(0, time.Minute); !unlimited.allow("a", start) || !unlimited.allow("a", start) {
+		t.Error("a limit of 0 should allow everything")
+	}
+}
+
+func TestSpamChecks(t *testing.T) {
+	sub := &SpamSubmission{Fields: map[string]string{
+		"message": "Visit https://a.example and http://b.example or www.c.example for CASINO deals",
+	}}
+	if score, _ := linkCheck(2)(sub); score != 5 {
+		t.Errorf("linkCheck score = %d, want 5", score)
+	}
+	if score, _ := linkCheck(3)(sub); score != 0 {
+		t.Errorf("linkCheck under the limit scored %d", score)
+	}
+	if score, reason := keywordCheck([]string{"casino", "crypto"})(sub); score != 3 || reason != `keywords "casino"` {
+		t.Errorf("keywordCheck = %d, %q", score, reason)
+	}
+}
+
+func TestRateLimitCountsValidSubmissions(t *testing.T) {
+	s := newTestServer(t)
+	s.spam.limiter = newRateLimiter(2, time.Minute)
+	addUser(t, s, "taken", RoleUser)
+
+	tests := []struct {
+		target string
+		form   string
+		fields url.Values
+		want   int
+	}{
+		// Mistakes are shown again without counting
+		{"/contact", SpamFormContact, url.Values{"name": {"Ann"}, "email": {"ann@"}, "message": {"Hi"}}, http.StatusUnprocessableEntity},
+		{"/contact", SpamFormContact, url.Values{"name": {"Ann"}, "email": {"ann@example.com"}}, http.StatusUnprocessableEntity},
+		{"/contact", SpamFormContact, url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "message": {"Hi"}}, http.StatusSeeOther},
+		{"/contact", SpamFormContact, url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "message": {"Hi again"}}, http.StatusSeeOther},
+		{"/contact", SpamFormContact, url.Values{"name": {"Ann"}, "email": {"ann@example.com"}, "message": {"Hi once more"}}, http.StatusTooManyRequests},
+		{"/register", SpamFormRegister, url.Values{"name": {"Bo"}, "email": {"bo@example.com"}, "username": {"b"}, "password": {"long enough"}}, http.StatusUnprocessableEntity},
+		// Taken usernames count, so they cannot be probed freely
+		{"/register", SpamFormRegister, url.Values{"name": {"Bo"}, "email": {"bo@example.com"}, "username": {"taken"}, "password": {"long enough"}}, http.StatusUnprocessableEntity},
+		{"/register", SpamFormRegister, url.Values{"name": {"Bo"}, "email": {"bo@example.com"}, "username": {"bob"}, "password": {"long enough"}}, http.StatusFound},
+		{"/register", SpamFormRegister, url.Values{"name": {"Cy"}, "email": {"cy@example.com"}, "username": {"cyd"}, "password": {"long enough"}}, http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		tt.fields.Set(spamTokenField, s.spam.token(tt.form, time.Now()))
+		if rec := do(s, nil, http.MethodPost, tt.target, tt.fields.Encode(), nil); rec.Code != tt.want {
+			t.Errorf("%d: %s %v = %d, want %d", i, tt.target, tt.fields, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestNewSpamGuardSettings(t *testing.T) {
+	tests := []struct {
+		key, value string
+		ok         bool
+	}{
+		{"SPAM_THRESHOLD", "1", true},
+		{"SPAM_THRESHOLD", "0", false},
+		{"SPAM_THRESHOLD", "-2", false},
+		{"SPAM_RATE_LIMIT", "0", true},
+		{"SPAM_RATE_WINDOW", "0s", false},
+		{"SPAM_MIN_SUBMIT_TIME", "soon", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			if _, err := newSpamGuard(); (err == nil) != tt.ok {
+				t.Errorf("newSpamGuard() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiError is the body of every JSON error response.
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the problems with each field of a rejected submission.
+	Fields ValidationErrors `json:"fields,omitempty"`
+}
+
+type apiListMeta struct {
+	// Page is left out of pages requested by cursor.
+	Page    int    `json:"page,omitempty"`
+	PerPage int    `json:"per_page"`
+	Total   int    `json:"total"`
+	Sort    string `json:"sort,omitempty"`
+	// NextCursor is passed as the after parameter to get the next page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type apiList struct {
+	Data interface{} `json:"data"`
+	Meta apiListMeta `json:"meta"`
+}
+
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+// postInput is the request body for POST, PUT and PATCH. Fields left out of
+// a PATCH body keep their current value.
+type postInput struct {
+	Title   *string `json:"title"`
+	Slug    *string `json:"slug"`
+	Content *string `json:"content"`
+	Format  *string `json:"format"`
+	// CategoryIDs and Tags replace the post's categories and tag names
+	// when present.
+	CategoryIDs *[]int     `json:"category_ids"`
+	Tags        *[]string  `json:"tags"`
+	Status      *string    `json:"status"`
+	PublishAt   *time.Time `json:"publish_at"`
+	// Note is stored as the change note of the revision the save creates.
+	Note string `json:"note"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorBody{Code: code, Message: message}})
+}
+
+// apiPostsHandler serves /api/v1/posts and /api/v1/posts/{id}.
+func (s *Server) apiPostsHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/posts"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.apiListPosts(w, r)
+		case http.MethodPost:
+			s.apiCreatePost(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed")
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(rest)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Post not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.apiGetPost(w, r, id)
+	case http.MethodPut, http.MethodPatch:
+		s.apiUpdatePost(w, r, id)
+	case http.MethodDelete:
+		s.apiDeletePost(w, r, id)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed")
+	}
+}
+
+// apiUser returns the signed-in user, writing a 401 response when there is
+// none.
+func (s *Server) apiUser(w http.ResponseWriter, r *http.Request) *User {
+	sess, err := s.currentSession(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return nil
+	}
+	return &User{ID: sess.UserID, Role: sess.Role}
+}
+
+// apiReader returns the signed-in user reading posts, or nil. Unpublished
+// posts are shown to the users who may edit them.
+func (s *Server) apiReader(r *http.Request) *User {
+	sess, err := s.currentSession(r)
+	if err != nil {
+		return nil
+	}
+	return &User{ID: sess.UserID, Role: sess.Role}
+}
+
+func (s *Server) apiListPosts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	req, err := parsePageRequest(q, postListing)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	filter := PostFilter{Query: q.Get("q"), Status: q.Get("status")}
+	if filter.Status != "" && !validPostStatus(filter.Status) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "status is not a valid post status")
+		return
+	}
+	reader := s.apiReader(r)
+	switch {
+	case s.perms.can(reader, PermPostsEditAny, nil):
+	case s.perms.mayHave(reader, PermPostsEdit):
+		// Authors see their own posts besides the published ones
+		filter.VisibleTo = reader.ID
+	default:
+		// Only published posts are public
+		if filter.Status != "" && filter.Status != PostPublished {
+			writeJSONError(w, http.StatusForbidden, "forbidden", "You may only list published posts")
+			return
+		}
+		filter.Status = PostPublished
+	}
+	filter.Tag = q.Get("tag")
+	if v := q.Get("author_id"); v != "" {
+		filter.AuthorID, err = strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "author_id must be an integer")
+			return
+		}
+	}
+
+	posts, pages, err := s.postPage(r, filter, req)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+
+	if err := s.loadPostTerms(posts...); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	s.renderPosts(posts...)
+	writeJSON(w, http.StatusOK, apiList{
+		Data: posts,
+		Meta: pages.meta(),
+	})
+}
+
+func (s *Server) apiGetPost(w http.ResponseWriter, r *http.Request, id int) {
+	post, err := s.posts.Get(id)
+	if err == nil && post.Status != PostPublished && !s.perms.can(s.apiReader(r), PermPostsEdit, post) {
+		err = ErrNotFound
+	}
+	if err == nil {
+		err = s.loadPostTerms(post)
+	}
+	if err != nil {
+		writePostLookupError(w, err)
+		return
+	}
+	s.renderPosts(post)
+	writeJSON(w, http.StatusOK, apiItem{Data: post})
+}
+
+func (s *Server) apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	user := s.apiUser(w, r)
+	if user == nil {
+		return
+	}
+	if !s.perms.can(user, PermPostsCreate, nil) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "You may not create posts")
+		return
+	}
+
+	var in postInput
+	if !decodeJSONBody(w, r, &in) {
+		return
+	}
+	post := &Post{Format: FormatMarkdown, AuthorID: user.ID, Status: PostDraft}
+	if in.Title != nil {
+		post.Title = *in.Title
+	}
+	if in.Content != nil {
+		post.Content = *in.Content
+	}
+	if in.Format != nil {
+		post.Format = *in.Format
+	}
+	v := &validator{}
+	validatePost(v, post.Title, post.Content, post.Format)
+	if !v.Valid() {
+		writeValidationErrors(w, v.Errors())
+		return
+	}
+	categoryIDs, tagNames, ok := s.apiPostTerms(w, post, in)
+	if !ok {
+		return
+	}
+	if !s.apiSetStatus(w, user, post, in) {
+		return
+	}
+	var slug string
+	if in.Slug != nil {
+		slug = *in.Slug
+	}
+	if err := s.assignSlug(post, slug); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	id, err := s.posts.Create(post)
+	if err == nil {
+		err = s.setPostTerms(post.ID, categoryIDs, tagNames)
+	}
+	if err == nil {
+		err = s.loadPostTerms(post)
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	if err := s.recordRevision(post, user.ID, in.Note); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	s.indexPost(post)
+
+	s.renderPosts(post)
+	w.Header().Set("Location", "/api/v1/posts/"+strconv.Itoa(id))
+	writeJSON(w, http.StatusCreated, apiItem{Data: post})
+}
+
+func (s *Server) apiUpdatePost(w http.ResponseWriter, r *http.Request, id int) {
+	user := s.apiUser(w, r)
+	if user == nil {
+		return
+	}
+
+	post, err := s.posts.Get(id)
+	if err != nil {
+		writePostLookupError(w, err)
+		return
+	}
+	if !s.perms.can(user, PermPostsEdit, post) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "You may not edit this post")
+		return
+	}
+
+	var in postInput
+	if !decodeJSONBody(w, r, &in) {
+		return
+	}
+	if err := s.loadPostTerms(post); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	categoryIDs, tagNames, ok := s.apiPostTerms(w, post, in)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPut {
+		// A PUT replaces the post, so a missing title or content is empty
+		if in.Title == nil {
+			in.Title = new(string)
+		}
+		if in.Content == nil {
+			in.Content = new(string)
+		}
+	}
+	if in.Title != nil {
+		post.Title = *in.Title
+	}
+	if in.Content != nil {
+		post.Content = *in.Content
+	}
+	if in.Format != nil {
+		post.Format = *in.Format
+	}
+	v := &validator{}
+	validatePost(v, post.Title, post.Content, post.Format)
+	if !v.Valid() {
+		writeValidationErrors(w, v.Errors())
+		return
+	}
+	if !s.apiSetStatus(w, user, post, in) {
+		return
+	}
+	var slug string
+	if in.Slug != nil {
+		slug = *in.Slug
+	}
+	if err := s.assignSlug(post, slug); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+
+	err = s.posts.Update(post)
+	if err == nil {
+		err = s.setPostTerms(post.ID, categoryIDs, tagNames)
+	}
+	if err == nil {
+		err = s.loadPostTerms(post)
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	if err := s.recordRevision(post, user.ID, in.Note); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	s.indexPost(post)
+	s.renderPosts(post)
+	writeJSON(w, http.StatusOK, apiItem{Data: post})
+}
+
+func (s *Server) apiDeletePost(w http.ResponseWriter, r *http.Request, id int) {
+	user := s.apiUser(w, r)
+	if user == nil {
+		return
+	}
+
+	post, err := s.posts.Get(id)
+	if err != nil {
+		writePostLookupError(w, err)
+		return
+	}
+	if !s.perms.can(user, PermPostsDelete, post) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "You may not delete this post")
+		return
+	}
+
+	if err := s.posts.Delete(id); err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+	s.unindexPost(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiSetStatus applies the status and publish time from the request body,
+// writing an error response and returning false when that is not allowed.
+func (s *Server) apiSetStatus(w http.ResponseWriter, user *User, post *Post, in postInput) bool {
+	status := post.Status
+	if in.Status != nil {
+		status = *in.Status
+	}
+	publishAt := in.PublishAt
+	if publishAt == nil && in.Status == nil {
+		publishAt = post.PublishAt
+	}
+
+	if !s.canSetPostStatus(user, post, status, publishAt) {
+		writeJSONError(w, http.StatusForbidden, "forbidden", "You may not publish posts")
+		return false
+	}
+	if err := setPostStatus(post, status, publishAt, time.Now()); err != nil {
+		v := &validator{}
+		checkPostStatus(v, err)
+		writeValidationErrors(w, v.Errors())
+		return false
+	}
+	return true
+}
+
+// apiPostTerms returns the categories and tag names the post should have
+// after applying in, keeping its current ones for omitted fields. It writes
+// an error response and returns false for unknown categories.
+func (s *Server) apiPostTerms(w http.ResponseWriter, post *Post, in postInput) ([]int, []string, bool) {
+	var categoryIDs []int
+	if in.CategoryIDs != nil {
+		categoryIDs = *in.CategoryIDs
+	} else {
+		for _, c := range post.Categories {
+			categoryIDs = append(categoryIDs, c.ID)
+		}
+	}
+	categoryIDs, err := s.checkCategories(categoryIDs)
+	if errors.Is(err, errUnknownCategory) {
+		writeValidationErrors(w, ValidationErrors{{Field: "category_ids", Message: "Choose from the existing categories."}})
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return nil, nil, false
+	}
+
+	var tagNames []string
+	if in.Tags != nil {
+		tagNames = *in.Tags
+	} else {
+		for _, t := range post.Tags {
+			tagNames = append(tagNames, t.Name)
+		}
+	}
+	return categoryIDs, tagNames, true
+}
+
+func writePostLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Post not found")
+		return
+	}
+	log.Println(err)
+	writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+}
+
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return false
+	}
+	return true
+}
+
+func positiveIntParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, errors.New("not a positive integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func decodeBody(t *testing.T, body []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+}
+
+func TestAPIDraftVisibility(t *testing.T) {
+	s := newTestServer(t)
+	alice := addUser(t, s, "alice", RoleAuthor)
+	bob := addUser(t, s, "bob", RoleAuthor)
+	editor := addUser(t, s, "editor", RoleEditor)
+
+	aliceDraft := &Post{Title: "Alice draft", Content: "x", Format: FormatPlain, AuthorID: alice.ID, Status: PostDraft}
+	bobDraft := &Post{Title: "Bob draft", Content: "x", Format: FormatPlain, AuthorID: bob.ID, Status: PostDraft}
+	published := &Post{Title: "Bob post", Content: "x", Format: FormatPlain, AuthorID: bob.ID, Status: PostPublished}
+	for _, p := range []*Post{aliceDraft, bobDraft, published} {
+		if _, err := s.posts.Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   []int
+	}{
+		{"anonymous", nil, []int{published.ID}},
+		{"author", signIn(t, s, alice), []int{aliceDraft.ID, published.ID}},
+		{"editor", signIn(t, s, editor), []int{aliceDraft.ID, bobDraft.ID, published.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(s, tt.cookie, http.MethodGet, "/api/v1/posts?sort=id", "", nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var res struct{ Data []Post }
+			decodeBody(t, rec.Body.Bytes(), &res)
+			var got []int
+			for _, p := range res.Data {
+				got = append(got, p.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("listed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("listed %v, want %v", got, tt.want)
+				}
+			}
+
+			// Another author's draft is not found either
+			rec = do(s, tt.cookie, http.MethodGet, "/api/v1/posts/"+strconv.Itoa(bobDraft.ID), "", nil)
+			wantStatus := http.StatusNotFound
+			if tt.name == "editor" {
+				wantStatus = http.StatusOK
+			}
+			if rec.Code != wantStatus {
+				t.Errorf("get draft: status = %d, want %d", rec.Code, wantStatus)
+			}
+		})
+	}
+}
+
+func TestAPICreatePostValidation(t *testing.T) {
+	s := newTestServer(t)
+	author := signIn(t, s, addUser(t, s, "author", RoleAuthor))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		fields []string
+	}{
+		{"valid", `{"title":"Hello","content":"Body"}`, http.StatusCreated, nil},
+		{"empty", `{}`, http.StatusUnprocessableEntity, []string{"title", "content"}},
+		{"bad format", `{"title":"Hello","content":"Body","format":"rtf"}`, http.StatusUnprocessableEntity, []string{"format"}},
+		{"unknown category", `{"title":"Hello","content":"Body","category_ids":[42]}`, http.StatusUnprocessableEntity, []string{"category_ids"}},
+		{"scheduled without time", `{"title":"Hello","content":"Body","status":"scheduled"}`, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(s, author, http.MethodPost, "/api/v1/posts", tt.body, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusUnprocessableEntity {
+				return
+			}
+			var res apiError
+			decodeBody(t, rec.Body.Bytes(), &res)
+			if res.Error.Code != "validation_failed" || len(res.Error.Fields) != len(tt.fields) {
+				t.Fatalf("error = %+v, want fields %v", res.Error, tt.fields)
+			}
+			for i, f := range tt.fields {
+				if res.Error.Fields[i].Field != f {
+					t.Errorf("field %d = %q, want %q", i, res.Error.Fields[i].Field, f)
+				}
+			}
+		})
+	}
+}
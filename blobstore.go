@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// BlobStore stores uploaded files by key. Keys are slash-separated paths
+// without "." or ".." elements; parts starting with a dot are reserved for
+// temporary files.
+type BlobStore interface {
+	// Put stores the content under key, replacing any existing blob.
+	Put(key string, r io.Reader, contentType string) error
+	// Get opens the blob for reading. It returns ErrNotFound when the blob
+	// does not exist.
+	Get(key string) (io.ReadSeekCloser, BlobInfo, error)
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+	// Stat describes the blob, or returns ErrNotFound.
+	Stat(key string) (BlobInfo, error)
+	// List describes every blob whose key starts with prefix, in key
+	// order.
+	List(prefix string) ([]BlobInfo, error)
+	// SignedURL returns an address the blob can be fetched from without
+	// signing in, valid for at least expiry.
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	// ETag changes whenever the content does.
+	ETag string
+}
+
+// newBlobStore opens the media store selected by MEDIA_STORE: "local"
+// keeps files in MEDIA_DIR, "s3" keeps them in an S3-compatible bucket.
+func newBlobStore() (BlobStore, error) {
+	switch store := getEnv("MEDIA_STORE", "local"); store {
+	case "local":
+		return newLocalBlobStore(getEnv("MEDIA_DIR", uploadDir))
+	case "s3":
+		return newS3BlobStore(s3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", ""),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    getEnv("S3_BUCKET", ""),
+			AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("S3_SECRET_KEY", ""),
+		})
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORE %q", store)
+	}
+}
+
+// validBlobKey reports whether key may name a blob.
+func validBlobKey(key string) bool {
+	if !fs.ValidPath(key) || key == "." || strings.ContainsAny(key, `\`+"\x00") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Config locates an S3-compatible bucket. Objects are addressed in path
+// style (endpoint/bucket/key), which MinIO and most other implementations
+// accept.
+type s3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// s3BlobStore keeps blobs as objects in an S3-compatible bucket. Requests
+// are signed with AWS Signature Version 4.
+type s3BlobStore struct {
+	cfg      s3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func newS3BlobStore(cfg s3Config) (*s3BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required")
+	}
+	u, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	return &s3BlobStore{cfg: cfg, endpoint: u, client: &http.Client{Timeout: time.Minute}}, nil
+}
+
+// s3Error is an unexpected response from the bucket.
+type s3Error struct {
+	Op     string
+	Key    string
+	Status int
+	Body   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 %s %q: %d %s", e.Op, e.Key, e.Status, e.Body)
+}
+
+func readS3Error(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &s3Error{Op: op, Key: key, Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+// objectURL returns the address of the object, or of the bucket when key
+// is empty.
+func (s *s3BlobStore) objectURL(key string, query url.Values) *url.URL {
+	p := "/" + s.cfg.Bucket
+	if key != "" {
+		p += "/" + key
+	}
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + p
+	u.RawPath = s.endpoint.Path + s3Escape(p, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// do sends a signed request for the object.
+func (s *s3BlobStore) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return s.client.Do(req)
+}
+
+func (s *s3BlobStore) Put(key string, r io.Reader, contentType string) error {
+	if !validBlobKey(key) {
+		return errors.New("invalid blob key " + strconv.Quote(key))
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readS3Error("put", key, resp)
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Get(key string) (io.ReadSeekCloser, BlobInfo, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return &s3Object{store: s, info: info}, info, nil
+}
+
+func (s *s3BlobStore) Delete(key string) error {
+	if !validBlobKey(key) {
+		return nil
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return readS3Error("delete", key, resp)
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Stat(key string) (BlobInfo, error) {
+	if !validBlobKey(key) {
+		return BlobInfo{}, ErrNotFound
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return BlobInfo{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, readS3Error("stat", key, resp)
+	}
+
+	info := BlobInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response that is used.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+		ETag         string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3BlobStore) List(prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := readS3Error("list", prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			if validBlobKey(c.Key) {
+				blobs = append(blobs, BlobInfo{Key: c.Key, Size: c.Size, ModTime: c.LastModified, ETag: c.ETag})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
+
+// SignedURL returns a presigned GET address for the object. S3 accepts
+// expiries of up to seven days.
+func (s *s3BlobStore) SignedURL(key string, expiry time.Duration) (string, error) {
+	if !validBlobKey(key) {
+		return "", ErrNotFound
+	}
+	if expiry <= 0 || expiry > 7*24*time.Hour {
+		return "", fmt.Errorf("invalid signed URL expiry %v", expiry)
+	}
+	return s.presign(http.MethodGet, s.objectURL(key, nil), expiry, time.Now()).String(), nil
+}
+
+// s3Object reads an object with ranged GET requests, so it can be seeked
+// without downloading the parts before the offset.
+type s3Object struct {
+	store  *s3BlobStore
+	info   BlobInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.info.Size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+		resp, err := o.store.do(http.MethodGet, o.info.Key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.offset == 0) {
+			defer resp.Body.Close()
+			return 0, readS3Error("get", o.info.Key, resp)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.info.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3TimeFormat      = "20060102T150405Z"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// sign adds the Signature Version 4 authorization to the request. The
+// host, the x-amz-* headers and the Content-Type, Content-MD5 and Range
+// headers are signed.
+func (s *s3BlobStore) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format(s3TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "content-md5" || k == "range" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	scope, signature := s.signature(req.Method, req.URL, canonicalHeaders.String(), signedHeaders, payloadHash, t)
+	req.Header.Set("Authorization", s3Algorithm+" Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// presign returns u with a Signature Version 4 query string that lets
+// anyone make the request until the expiry passes.
+func (s *s3BlobStore) presign(method string, u *url.URL, expiry time.Duration, t time.Time) *url.URL {
+	amzDate := t.UTC().Format(s3TimeFormat)
+	scope := s.scope(t)
+
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", s3Algorithm)
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	signed := *u
+	signed.RawQuery = s3Query(q)
+	_, signature := s.signature(method, &signed, "host:"+u.Host+"\n", "host", s3UnsignedPayload, t)
+	signed.RawQuery += "&X-Amz-Signature=" + signature
+	return &signed
+}
+
+func (s *s3BlobStore) scope(t time.Time) string {
+	return t.UTC().Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature returns the credential scope and the signature of a request.
+func (s *s3BlobStore) signature(method string, u *url.URL, canonicalHeaders, signedHeaders, payloadHash string, t time.Time) (string, string) {
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := s.scope(t)
+	stringToSign := s3Algorithm + "\n" + t.UTC().Format(s3TimeFormat) + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), t.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Query returns the canonical form of a query string: sorted by name,
+// with names and values escaped as Signature Version 4 requires.
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes every byte but the unreserved characters, and
+// the slash unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
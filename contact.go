@@ -0,0 +1,565 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contact entry statuses
+const (
+	ContactNew      = "new"
+	ContactRead     = "read"
+	ContactReplied  = "replied"
+	ContactArchived = "archived"
+	ContactSpam     = "spam"
+)
+
+var contactStatuses = []string{ContactNew, ContactRead, ContactReplied, ContactArchived, ContactSpam}
+
+// contactInbox lists the statuses of entries still being handled. The inbox
+// shows them unless another status is asked for.
+var contactInbox = []string{ContactNew, ContactRead, ContactReplied}
+
+func validContactStatus(status string) bool {
+	for _, st := range contactStatuses {
+		if status == st {
+			return true
+		}
+	}
+	return false
+}
+
+type ContactEntry struct {
+	ID         int
+	Name       string
+	Email      string
+	Message    string
+	ReceivedAt time.Time
+	Status     string
+	// AssigneeID is the user handling the entry, or 0.
+	AssigneeID int
+}
+
+// ContactNote is an internal note on a contact entry. Submitters never see
+// notes.
+type ContactNote struct {
+	ID        int
+	EntryID   int
+	AuthorID  int
+	Body      string
+	CreatedAt time.Time
+}
+
+// ContactReply is an email sent from the inbox to the author of a contact
+// entry.
+type ContactReply struct {
+	ID        int
+	EntryID   int
+	AuthorID  int
+	Subject   string
+	Body      string
+	CreatedAt time.Time
+}
+
+// ContactFilter narrows the entries returned by ContactRepository.ListPage.
+type ContactFilter struct {
+	// Statuses matches entries in any of the statuses.
+	Statuses []string
+	// AssigneeID matches entries assigned to the user.
+	AssigneeID int
+}
+
+// Contact form limits
+const (
+	maxContactNameLength    = 255
+	maxContactMessageLength = 5000
+	maxContactNoteLength    = 5000
+	maxContactSubjectLength = 255
+	maxContactReplyLength   = 5000
+)
+
+// contactHandler shows the contact form and records the messages sent with
+// it.
+func (s *Server) contactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Parse the form data
+		err := r.ParseForm()
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Retrieve the form values
+		name := strings.TrimSpace(r.Form.Get("name"))
+		email := strings.TrimSpace(r.Form.Get("email"))
+		message := strings.TrimSpace(r.Form.Get("message"))
+
+		// Check the form values, and show the form again with the
+		// problems found
+		v := &validator{}
+		v.Required("name", name)
+		v.MaxLength("name", name, maxContactNameLength)
+		v.Required("email", email)
+		v.Email("email", email)
+		v.Required("message", message)
+		v.MaxLength("message", message, maxContactMessageLength)
+		if !v.Valid() {
+			s.contactFormErrors(w, r, v.Errors())
+			return
+		}
+
+		// Keep out spam. Only valid submissions are screened, so mistakes
+		// do not count towards the rate limit
+		sub := &SpamSubmission{Form: SpamFormContact, Fields: map[string]string{"name": name, "email": email, "message": message}}
+		if !s.screenSubmission(w, r, sub) {
+			return
+		}
+
+		// Insert the form data into the database
+		entry := &ContactEntry{Name: name, Email: email, Message: message, ReceivedAt: time.Now().UTC(), Status: ContactNew}
+		err = s.contacts.Create(entry)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		s.indexContact(entry)
+		s.notifyContact(entry)
+
+		http.Redirect(w, r, "/contact/sent", http.StatusSeeOther)
+		return
+	}
+
+	// Render the contact form template
+	tpl, err := template.ParseFiles("templates/contact.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, publicForm{spamForm: s.spamFormData(SpamFormContact)})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// contactFormErrors answers a contact form submission that did not pass
+// validation, rendering the form again with the message kept.
+func (s *Server) contactFormErrors(w http.ResponseWriter, r *http.Request, errs ValidationErrors) {
+	if wantsJSON(r) {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	tpl, err := template.ParseFiles("templates/contact.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := publicForm{
+		spamForm:  s.spamFormData(SpamFormContact),
+		formState: formState{Values: r.PostForm, Errors: errs},
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// contactSentHandler confirms to the submitter that their message arrived.
+func (s *Server) contactSentHandler(w http.ResponseWriter, r *http.Request) {
+	tpl, err := template.ParseFiles("templates/contact_sent.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = tpl.Execute(w, nil)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// contactAssignees returns the users who can handle contact entries.
+func (s *Server) contactAssignees() ([]User, error) {
+	users, err := s.users.List()
+	if err != nil {
+		return nil, err
+	}
+	var assignees []User
+	for _, u := range users {
+		if s.perms.has(u.Role, PermContactsRead) {
+			assignees = append(assignees, u)
+		}
+	}
+	return assignees, nil
+}
+
+// notifyContact emails the staff who can read the inbox about a new entry.
+// Failures are logged, as the entry is stored either way.
+func (s *Server) notifyContact(entry *ContactEntry) {
+	staff, err := s.contactAssignees()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	msg, err := renderMail("contact_notification", entry)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, u := range staff {
+		if u.Email == "" {
+			continue
+		}
+		m := *msg
+		m.To = []string{(&mail.Address{Name: u.Name, Address: u.Email}).String()}
+		if err := s.queueMail(&m); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// contactListItem is an entry in the inbox with its assignee's name.
+type contactListItem struct {
+	ContactEntry
+	Assignee string
+}
+
+// Excerpt returns the start of the message for the inbox.
+func (e ContactEntry) Excerpt() string {
+	if runes := []rune(e.Message); len(runes) > 100 {
+		return string(runes[:100]) + "…"
+	}
+	return e.Message
+}
+
+// getContactListHandler shows the contact inbox and changes the status of
+// the selected entries.
+func (s *Server) getContactListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Set the status of every selected entry
+		status := r.FormValue("status")
+		if !validContactStatus(status) {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		for _, v := range r.Form["id"] {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if err := s.contacts.SetStatus(id, status); err != nil && !errors.Is(err, ErrNotFound) {
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		back := "/contact/list"
+		if q := r.FormValue("return"); strings.HasPrefix(q, "?") {
+			back += q
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	// Read the filter: a status tab and, optionally, one assignee
+	q := r.URL.Query()
+	status := q.Get("status")
+	filter := ContactFilter{Statuses: contactInbox}
+	if status != "" {
+		if !validContactStatus(status) {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		filter.Statuses = []string{status}
+	}
+	switch v := q.Get("assignee"); v {
+	case "":
+	case "me":
+		filter.AssigneeID = currentUser(r).ID
+	default:
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		filter.AssigneeID = id
+	}
+	req, err := parsePageRequest(q, contactListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve a page of contact entries from the database
+	entries, pages, err := s.contactPage(r, filter, req)
+	var counts map[string]int
+	if err == nil {
+		counts, err = s.contacts.StatusCounts()
+	}
+	var assignees []User
+	if err == nil {
+		assignees, err = s.contactAssignees()
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	names := make(map[int]string, len(assignees))
+	for _, u := range assignees {
+		names[u.ID] = u.Username
+	}
+	items := make([]contactListItem, len(entries))
+	for i, e := range entries {
+		items[i] = contactListItem{ContactEntry: e, Assignee: names[e.AssigneeID]}
+	}
+	type statusTab struct {
+		Status string
+		Count  int
+	}
+	tabs := make([]statusTab, len(contactStatuses))
+	for i, st := range contactStatuses {
+		tabs[i] = statusTab{Status: st, Count: counts[st]}
+	}
+	inbox := 0
+	for _, st := range contactInbox {
+		inbox += counts[st]
+	}
+
+	// Render the contact list template
+	tpl, err := template.ParseFiles("templates/contact_list.html", "templates/pagination.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Entries   []contactListItem
+		Pages     *Pagination
+		Status    string
+		Assignee  string
+		Tabs      []statusTab
+		Inbox     int
+		Statuses  []string
+		Assignees []User
+		Query     string
+	}{
+		Entries:   items,
+		Pages:     pages,
+		Status:    status,
+		Assignee:  q.Get("assignee"),
+		Tabs:      tabs,
+		Inbox:     inbox,
+		Statuses:  contactStatuses,
+		Assignees: assignees,
+		Query:     r.URL.RawQuery,
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// contactNoteItem is a note with its author's name.
+type contactNoteItem struct {
+	ContactNote
+	Author string
+}
+
+// contactReplyItem is a reply with its author's name.
+type contactReplyItem struct {
+	ContactReply
+	Author string
+}
+
+// contactEntryHandler shows a contact entry with its notes and replies,
+// changes its status, assignee and notes, and replies to its author.
+// Opening a new entry marks it read.
+func (s *Server) contactEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	entry, err := s.contacts.Get(id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "status":
+			status := r.FormValue("status")
+			if !validContactStatus(status) {
+				http.Error(w, "Invalid status", http.StatusBadRequest)
+				return
+			}
+			err = s.contacts.SetStatus(entry.ID, status)
+		case "assign":
+			assigneeID, _ := strconv.Atoi(r.FormValue("assignee_id"))
+			if assigneeID != 0 {
+				assignees, err := s.contactAssignees()
+				if err != nil {
+					log.Println(err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				if !containsUser(assignees, assigneeID) {
+					http.Error(w, "Unknown assignee", http.StatusBadRequest)
+					return
+				}
+			}
+			err = s.contacts.Assign(entry.ID, assigneeID)
+		case "note":
+			body := strings.TrimSpace(r.FormValue("body"))
+			if body == "" || len([]rune(body)) > maxContactNoteLength {
+				http.Error(w, "A note must have between 1 and 5000 characters", http.StatusBadRequest)
+				return
+			}
+			note := &ContactNote{EntryID: entry.ID, AuthorID: currentUser(r).ID, Body: body, CreatedAt: time.Now().UTC()}
+			_, err = s.contacts.AddNote(note)
+		case "reply":
+			subject := strings.TrimSpace(r.FormValue("subject"))
+			body := strings.TrimSpace(r.FormValue("body"))
+			if subject == "" || len([]rune(subject)) > maxContactSubjectLength || body == "" || len([]rune(body)) > maxContactReplyLength {
+				http.Error(w, "A reply must have a subject of at most 255 characters and between 1 and 5000 characters of text", http.StatusBadRequest)
+				return
+			}
+			reply := &ContactReply{EntryID: entry.ID, AuthorID: currentUser(r).ID, Subject: subject, Body: body, CreatedAt: time.Now().UTC()}
+			err = s.replyToContact(entry, reply)
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/contact/entry?id="+strconv.Itoa(entry.ID), http.StatusSeeOther)
+		return
+	}
+
+	// Mark a new entry read once someone has opened it
+	if entry.Status == ContactNew {
+		if err := s.contacts.SetStatus(entry.ID, ContactRead); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		entry.Status = ContactRead
+	}
+
+	// Retrieve the notes, the replies and the people who can be assigned
+	notes, err := s.contacts.Notes(entry.ID)
+	var replies []ContactReply
+	var assignees []User
+	var users []User
+	if err == nil {
+		replies, err = s.contacts.Replies(entry.ID)
+	}
+	if err == nil {
+		assignees, err = s.contactAssignees()
+	}
+	if err == nil {
+		users, err = s.users.List()
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	names := make(map[int]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	items := make([]contactNoteItem, len(notes))
+	for i, n := range notes {
+		items[i] = contactNoteItem{ContactNote: n, Author: names[n.AuthorID]}
+	}
+	replyItems := make([]contactReplyItem, len(replies))
+	for i, reply := range replies {
+		replyItems[i] = contactReplyItem{ContactReply: reply, Author: names[reply.AuthorID]}
+	}
+
+	// Render the entry page
+	tpl, err := template.ParseFiles("templates/contact_entry.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Entry     *ContactEntry
+		Notes     []contactNoteItem
+		Replies   []contactReplyItem
+		Statuses  []string
+		Assignees []User
+	}{
+		Entry:     entry,
+		Notes:     items,
+		Replies:   replyItems,
+		Statuses:  contactStatuses,
+		Assignees: assignees,
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// replyToContact queues the reply for the entry's author, quoting their
+// message, then records it and marks the entry replied.
+func (s *Server) replyToContact(entry *ContactEntry, reply *ContactReply) error {
+	quoted := "> " + strings.ReplaceAll(strings.TrimSpace(entry.Message), "\n", "\n> ")
+	msg, err := renderMail("contact_reply", struct {
+		Entry  *ContactEntry
+		Reply  *ContactReply
+		Quoted string
+	}{entry, reply, quoted})
+	if err != nil {
+		return err
+	}
+	msg.To = []string{(&mail.Address{Name: entry.Name, Address: entry.Email}).String()}
+	if err := s.queueMail(msg); err != nil {
+		return err
+	}
+	if _, err := s.contacts.AddReply(reply); err != nil {
+		return err
+	}
+	return s.contacts.SetStatus(entry.ID, ContactReplied)
+}
+
+func containsUser(users []User, id int) bool {
+	for _, u := range users {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContactReply(t *testing.T) {
+	s := newTestServer(t)
+	entry := &ContactEntry{Name: "Ann Lee", Email: "ann@example.com", Message: "Hello\nthere", ReceivedAt: time.Now().UTC(), Status: ContactRead}
+	if err := s.contacts.Create(entry); err != nil {
+		t.Fatal(err)
+	}
+	admin := signIn(t, s, addUser(t, s, "admin", RoleAdmin))
+	target := "/contact/entry?id=" + strconv.Itoa(entry.ID)
+
+	// Invalid replies are refused and nothing is sent
+	for _, body := range []string{"action=reply&subject=Re&body=+", "action=reply&subject=&body=Thanks"} {
+		if rec := do(s, admin, http.MethodPost, target, body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: code = %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec := do(s, admin, http.MethodPost, target, "action=reply&subject=Re%3A+your+message&body=Thanks+for+writing.", nil)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("code = %d, want 303", rec.Code)
+	}
+	if err := s.deliverOutbox(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reply is mailed to the sender with their message quoted
+	sent := s.mailer.(*memoryMailer).Messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	msg := sent[0]
+	if len(msg.To) != 1 || msg.To[0] != `"Ann Lee" <ann@example.com>` || msg.Subject != "Re: your message" {
+		t.Errorf("to, subject = %q, %q", msg.To, msg.Subject)
+	}
+	if !strings.HasPrefix(msg.Text, "Thanks for writing.\n") || !strings.Contains(msg.Text, "> Hello\n> there\n") {
+		t.Errorf("text = %q", msg.Text)
+	}
+
+	// It is kept with the entry, which is marked replied
+	replies, err := s.contacts.Replies(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].Body != "Thanks for writing." {
+		t.Errorf("replies = %+v", replies)
+	}
+	if got, _ := s.contacts.Get(entry.ID); got.Status != ContactReplied {
+		t.Errorf("status = %s, want %s", got.Status, ContactReplied)
+	}
+	if page := do(s, admin, http.MethodGet, target, "", nil).Body.String(); !strings.Contains(page, "Thanks for writing.") {
+		t.Error("the entry page does not show the reply")
+	}
+}
+
+func TestContactNotification(t *testing.T) {
+	s := newTestServer(t)
+	addUser(t, s, "admin", RoleAdmin)
+	addUser(t, s, "editor", RoleEditor)
+
+	form := url.Values{
+		"name":         {"Ann Lee"},
+		"email":        {"ann@example.com"},
+		"message":      {"Do you ship abroad?"},
+		spamTokenField: {s.spam.token(SpamFormContact, time.Now())},
+	}
+	if rec := do(s, nil, http.MethodPost, "/contact", form.Encode(), nil); rec.Code != http.StatusSeeOther {
+		t.Fatalf("code = %d, want 303: %s", rec.Code, rec.Body)
+	}
+	if err := s.deliverOutbox(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the users who can read the inbox are told
+	sent := s.mailer.(*memoryMailer).Messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	msg := sent[0]
+	if len(msg.To) != 1 || !strings.Contains(msg.To[0], "<admin@example.com>") || msg.Subject != "New message from Ann Lee" {
+		t.Errorf("to, subject = %q, %q", msg.To, msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Do you ship abroad?") || !strings.Contains(msg.Text, "/contact/entry?id=1") {
+		t.Errorf("text = %q", msg.Text)
+	}
+}
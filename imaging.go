@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Derivative is a scaled-down copy of an image, stored next to the
+// original.
+type Derivative struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"` // key in the media store
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+}
+
+// derivativeSize is a named box that images are scaled down to fit.
+type derivativeSize struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// defaultImageSizes is used when IMAGE_SIZES is not set.
+const defaultImageSizes = "thumbnail=200x200,medium=800x800,large=1600x1600"
+
+// maxImagePixels guards against images that would take too much memory to
+// decode.
+const maxImagePixels = 50_000_000
+
+// jpegQuality is used for derivatives and re-encoded originals.
+const jpegQuality = 85
+
+// imageProcessor generates the derivatives of uploaded images.
+type imageProcessor struct {
+	sizes []derivativeSize
+	// cwebp is the path of the cwebp tool, or empty when WebP copies are
+	// disabled.
+	cwebp string
+}
+
+var sizeSpecRe = regexp.MustCompile(`^([a-z0-9]+)=(\d+)x(\d+)$`)
+
+// newImageProcessor reads the derivative settings: IMAGE_SIZES lists the
+// sizes as name=WIDTHxHEIGHT, and IMAGE_WEBP=true adds WebP copies made
+// with the cwebp tool.
+func newImageProcessor() (*imageProcessor, error) {
+	p := &imageProcessor{}
+	for _, spec := range strings.Split(getEnv("IMAGE_SIZES", defaultImageSizes), ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		m := sizeSpecRe.FindStringSubmatch(spec)
+		if m == nil {
+			return nil, fmt.Errorf("invalid IMAGE_SIZES entry %q", spec)
+		}
+		w, _ := strconv.Atoi(m[2])
+		h, _ := strconv.Atoi(m[3])
+		if w == 0 || h == 0 {
+			return nil, fmt.Errorf("invalid IMAGE_SIZES entry %q", spec)
+		}
+		p.sizes = append(p.sizes, derivativeSize{Name: m[1], Width: w, Height: h})
+	}
+
+	if getEnv("IMAGE_WEBP", "false") == "true" {
+		cwebp, err := exec.LookPath("cwebp")
+		if err != nil {
+			log.Println("IMAGE_WEBP is set but cwebp was not found; WebP copies are disabled")
+		}
+		p.cwebp = cwebp
+	}
+	return p, nil
+}
+
+// prepareOriginal removes the metadata of an uploaded file. JPEG photos
+// that are stored rotated are turned upright first, since their
+// orientation tag goes with the metadata.
+func prepareOriginal(data []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		clean, orientation, err := stripJPEG(data)
+		if err != nil || orientation <= 1 || orientation > 8 {
+			return clean, err
+		}
+		pic, err := jpeg.Decode(bytes.NewReader(clean))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, orient(pic, orientation), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "image/png":
+		return stripPNG(data)
+	case "image/webp":
+		return stripWebP(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+	return data, nil
+}
+
+var errBadImage = errors.New("malformed image")
+
+// stripJPEG drops the EXIF, XMP, IPTC and comment segments of a JPEG file
+// and returns the EXIF orientation it had.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), data[:2]...)
+	orientation := 0
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, 0, errBadImage
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// The image data runs to the end of the file
+			return append(out, data[i:]...), orientation, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errBadImage
+		}
+		segment := data[i:end]
+		switch marker {
+		case 0xE1: // APP1: EXIF or XMP
+			if o := exifOrientation(segment[4:]); o != 0 {
+				orientation = o
+			}
+		case 0xED, 0xFE: // APP13 (IPTC) and comments
+		default:
+			out = append(out, segment...)
+		}
+		i = end
+	}
+	return nil, 0, errBadImage
+}
+
+// exifOrientation reads the orientation tag from an APP1 payload, or
+// returns 0.
+func exifOrientation(p []byte) int {
+	if len(p) < 14 || string(p[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := p[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; e+12 <= len(tiff) && n > 0; e, n = e+12, n-1 {
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			return int(order.Uint16(tiff[e+8:]))
+		}
+	}
+	return 0
+}
+
+// stripPNG drops the EXIF, text and time chunks of a PNG file.
+func stripPNG(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(signature) || string(data[:len(signature)]) != signature {
+		return nil, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), data[:len(signature)]...)
+	for i := len(signature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errBadImage
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i {
+			return nil, errBadImage
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of a WebP file.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errBadImage
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i {
+			return nil, errBadImage
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			// Clear the EXIF and XMP flags
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// gifApplications are the GIF application extensions kept in uploads: the
+// animation loop counts and the colour profile.
+var gifApplications = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true, "ICCRGBG1012": true}
+
+// stripGIF drops the comment extensions of a GIF file and the application
+// extensions other than gifApplications, such as XMP.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errBadImage
+	}
+	// The header, screen descriptor and global colour table stay
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errBadImage
+	}
+	out := append(make([]byte, 0, len(data)), data[:i]...)
+
+	// subBlocks returns the end of the data sub-blocks starting at j
+	subBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			if data[j] == 0 {
+				return j + 1, nil
+			}
+			j += 1 + int(data[j])
+		}
+		return 0, errBadImage
+	}
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // Trailer
+			return append(out, data[i]), nil
+		case 0x2C: // Image descriptor, colour table and image data
+			if i+10 > len(data) {
+				return nil, errBadImage
+			}
+			start := i + 10
+			if data[i+9]&0x80 != 0 {
+				start += 3 << (data[i+9]&0x07 + 1)
+			}
+			// Skip the LZW code size
+			end, err := subBlocks(start + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		case 0x21: // Extension
+			if i+2 > len(data) {
+				return nil, errBadImage
+			}
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			label := data[i+1]
+			keep := label != 0xFE && label != 0xFF
+			if label == 0xFF && i+14 <= end && data[i+2] == 11 {
+				keep = gifApplications[string(data[i+3:i+14])]
+			}
+			if keep {
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		default:
+			return nil, errBadImage
+		}
+	}
+	// Close files cut short after a whole block
+	return append(out, 0x3B), nil
+}
+
+// orient turns an image stored with the given EXIF orientation upright.
+func orient(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// derive stores the derivatives of an image next to it, from the stored
+// file's content. Sizes the original already fits in are skipped.
+// Derivatives are JPEG, or PNG for images with transparency; animated GIFs
+// keep only their first frame. With WebP enabled every derivative also
+// gets a WebP copy, and JPEG and PNG originals get a full-size one.
+func (p *imageProcessor) derive(store BlobStore, img *Image, data []byte) ([]Derivative, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(img.Path, path.Ext(img.Path))
+	var derivatives []Derivative
+	put := func(d Derivative, content []byte) error {
+		d.Size = int64(len(content))
+		if err := store.Put(d.Path, bytes.NewReader(content), d.MIMEType); err != nil {
+			return err
+		}
+		derivatives = append(derivatives, d)
+		return nil
+	}
+	for _, size := range p.sizes {
+		w, h := fitSize(img.Width, img.Height, size.Width, size.Height)
+		if w >= img.Width && h >= img.Height {
+			continue
+		}
+
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+		var buf bytes.Buffer
+		d := Derivative{Name: size.Name, Width: w, Height: h}
+		if dst.Opaque() {
+			d.MIMEType, d.Path = "image/jpeg", base+"-"+size.Name+".jpg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			d.MIMEType, d.Path = "image/png", base+"-"+size.Name+".png"
+			err = png.Encode(&buf, dst)
+		}
+		if err == nil {
+			err = put(d, buf.Bytes())
+		}
+		if err != nil {
+			return derivatives, err
+		}
+
+		if p.cwebp != "" {
+			webp, err := p.webp(buf.Bytes())
+			if err == nil {
+				d.MIMEType, d.Path = "image/webp", base+"-"+size.Name+".webp"
+				err = put(d, webp)
+			}
+			if err != nil {
+				return derivatives, err
+			}
+		}
+	}
+
+	// Browsers that take WebP need a copy at full size too
+	if p.cwebp != "" && (img.MIMEType == "image/jpeg" || img.MIMEType == "image/png") {
+		webp, err := p.webp(data)
+		if err == nil {
+			err = put(Derivative{Name: "full", Path: base + "-full.webp", MIMEType: "image/webp", Width: img.Width, Height: img.Height}, webp)
+		}
+		if err != nil {
+			return derivatives, err
+		}
+	}
+	return derivatives, nil
+}
+
+// webp converts a JPEG or PNG image to WebP with cwebp.
+func (p *imageProcessor) webp(data []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "webp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out.webp")
+	if err := os.WriteFile(in, data, 0600); err != nil {
+		return nil, err
+	}
+	msg, err := exec.Command(p.cwebp, "-quiet", "-q", "80", "-metadata", "none", in, "-o", out).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("cwebp: %v: %s", err, msg)
+	}
+	return os.ReadFile(out)
+}
+
+// fitSize scales width and height down to fit in the box, keeping the
+// aspect ratio.
+func fitSize(width, height, boxWidth, boxHeight int) (int, int) {
+	if width <= boxWidth && height <= boxHeight {
+		return width, height
+	}
+	if width*boxHeight > height*boxWidth {
+		return boxWidth, max(1, height*boxWidth/width)
+	}
+	return max(1, width*boxHeight/height), boxHeight
+}
+
+// URL returns the address the image is served from.
+func (img Image) URL() string {
+	return mediaURL(img.Path)
+}
+
+// URL returns the address the derivative is served from.
+func (d Derivative) URL() string {
+	return mediaURL(d.Path)
+}
+
+// ThumbnailURL returns the address of the smallest copy of the image.
+func (img Image) ThumbnailURL() string {
+	best := img.Path
+	width := img.Width
+	for _, d := range img.Derivatives {
+		if d.MIMEType != "image/webp" && (width == 0 || d.Width < width) {
+			best, width = d.Path, d.Width
+		}
+	}
+	return mediaURL(best)
+}
+
+// SrcSet returns the srcset attribute listing the image and its
+// derivatives by width, or an empty string when it has none.
+func (img Image) SrcSet() string {
+	return img.srcSet(func(d Derivative) bool { return d.MIMEType != "image/webp" }, true)
+}
+
+// WebPSrcSet returns the srcset of the WebP derivatives, or an empty
+// string when there are none.
+func (img Image) WebPSrcSet() string {
+	return img.srcSet(func(d Derivative) bool { return d.MIMEType == "image/webp" }, img.MIMEType == "image/webp")
+}
+
+func (img Image) srcSet(keep func(Derivative) bool, original bool) string {
+	var ds []Derivative
+	for _, d := range img.Derivatives {
+		if keep(d) {
+			ds = append(ds, d)
+		}
+	}
+	if len(ds) == 0 {
+		return ""
+	}
+	if original && img.Width > 0 {
+		ds = append(ds, Derivative{Path: img.Path, Width: img.Width})
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Width < ds[j].Width })
+
+	parts := make([]string, len(ds))
+	for i, d := range ds {
+		parts[i] = mediaURL(d.Path) + " " + strconv.Itoa(d.Width) + "w"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadDerivatives fills in the derivatives of the images.
+func (s *Server) loadDerivatives(images []Image) error {
+	for i := range images {
+		var err error
+		if images[i].Derivatives, err = s.gallery.Derivatives(images[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deriveImage makes and records the derivatives of a stored image from its
+// content. Errors are logged; files of a failed attempt are removed.
+func (s *Server) deriveImage(img *Image, data []byte) {
+	derivatives, err := s.images.derive(s.media, img, data)
+	if err == nil {
+		err = s.gallery.SetDerivatives(img.ID, derivatives)
+	}
+	if err != nil {
+		log.Printf("deriving image %d: %v", img.ID, err)
+		for _, d := range derivatives {
+			s.media.Delete(d.Path)
+		}
+		return
+	}
+	img.Derivatives = derivatives
+}
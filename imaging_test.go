@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// gifExtension returns a GIF extension block holding the payload in one
+// sub-block.
+func gifExtension(label byte, payload string) []byte {
+	return append([]byte{0x21, label, byte(len(payload))}, append([]byte(payload), 0)...)
+}
+
+func TestStripGIF(t *testing.T) {
+	// Encode a two frame animation that loops three times
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 3}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a comment and XMP before the trailer
+	data := append([]byte(nil), buf.Bytes()[:buf.Len()-1]...)
+	data = append(data, gifExtension(0xFE, "secret comment")...)
+	data = append(data, gifExtension(0xFF, "XMP DataXMP<x:xmpmeta/>")...)
+	data = append(data, 0x3B)
+
+	clean, err := prepareOriginal(data, "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clean, buf.Bytes()) {
+		t.Errorf("stripped file differs from the original encoding:\n%q\n%q", clean, buf.Bytes())
+	}
+	got, err := gif.DecodeAll(bytes.NewReader(clean))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Image) != 2 || got.LoopCount != 3 {
+		t.Errorf("frames, loop count = %d, %d, want 2, 3", len(got.Image), got.LoopCount)
+	}
+
+	for _, bad := range [][]byte{[]byte("GIF89a"), data[:20], append([]byte("GIF89a"), make([]byte, 10)...)} {
+		if _, err := stripGIF(bad); err == nil {
+			t.Errorf("stripGIF(%q) accepted a malformed file", bad)
+		}
+	}
+}
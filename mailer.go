@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Message is an email. Addresses may carry a display name, as in
+// "Ann <ann@example.com>". At least one of Text and HTML must be set.
+type Message struct {
+	From    string
+	To      []string
+	ReplyTo string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// defaultMailFrom is the sender of the application's mail when MAIL_FROM
+// is not set.
+const defaultMailFrom = "noreply@localhost"
+
+// Mailer delivers email.
+type Mailer interface {
+	// Send delivers the message, or returns why it could not.
+	Send(msg *Message) error
+}
+
+// newMailer returns the mail transport selected by MAIL_TRANSPORT: "smtp"
+// sends through SMTP_HOST, "file" writes .eml files to MAIL_DIR, "memory"
+// keeps messages in memory and "log" writes them to the log.
+func newMailer() (Mailer, error) {
+	switch transport := getEnv("MAIL_TRANSPORT", "log"); transport {
+	case "smtp":
+		return newSMTPMailer(smtpConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			TLS:      getEnv("SMTP_TLS", "starttls"),
+		})
+	case "file":
+		return newFileMailer(getEnv("MAIL_DIR", "mail"))
+	case "memory":
+		return &memoryMailer{}, nil
+	case "log":
+		return logMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", transport)
+	}
+}
+
+// check reports whether the message can be sent.
+func (m *Message) check() error {
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("invalid sender %q: %v", m.From, err)
+	}
+	if len(m.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", to, err)
+		}
+	}
+	if m.ReplyTo != "" {
+		if _, err := mail.ParseAddress(m.ReplyTo); err != nil {
+			return fmt.Errorf("invalid reply-to address %q: %v", m.ReplyTo, err)
+		}
+	}
+	if m.Text == "" && m.HTML == "" {
+		return errors.New("message has no body")
+	}
+	return nil
+}
+
+// envelope returns the bare sender and recipient addresses.
+func (m *Message) envelope() (string, []string, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", nil, err
+	}
+	to := make([]string, len(m.To))
+	for i, v := range m.To {
+		addr, err := mail.ParseAddress(v)
+		if err != nil {
+			return "", nil, err
+		}
+		to[i] = addr.Address
+	}
+	return from.Address, to, nil
+}
+
+// Bytes encodes the message in the Internet Message Format, with a
+// text/plain and a text/html alternative when both bodies are set.
+func (m *Message) Bytes(date time.Time) ([]byte, error) {
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	from, _ := mail.ParseAddress(m.From)
+	to := make([]string, len(m.To))
+	for i, v := range m.To {
+		addr, _ := mail.ParseAddress(v)
+		to[i] = addr.String()
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", strings.Join(to, ", "))
+	if m.ReplyTo != "" {
+		addr, _ := mail.ParseAddress(m.ReplyTo)
+		header("Reply-To", addr.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	id, err := messageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+	header("Message-ID", id)
+	header("MIME-Version", "1.0")
+
+	// A single body is sent as is; two go in a multipart/alternative,
+	// plain text first
+	if m.Text == "" || m.HTML == "" {
+		contentType, body := "text/plain; charset=utf-8", m.Text
+		if m.HTML != "" {
+			contentType, body = "text/html; charset=utf-8", m.HTML
+		}
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	buf.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, body); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID returns a unique Message-ID in the sender's domain.
+func messageID(sender string) (string, error) {
+	domain := "localhost"
+	if i := strings.LastIndex(sender, "@"); i >= 0 {
+		domain = sender[i+1:]
+	}
+	id, err := randomName()
+	if err != nil {
+		return "", err
+	}
+	return "<" + id + "@" + domain + ">", nil
+}
+
+// mailTemplateDir holds the email templates. A message named n is made of
+// n.txt, which also defines the "subject" template, and an optional n.html.
+const mailTemplateDir = "templates/mail"
+
+// renderMail renders the named email template for the given data. The
+// caller fills in the addresses.
+func renderMail(name string, data interface{}) (*Message, error) {
+	txt, err := texttemplate.ParseFiles(filepath.Join(mailTemplateDir, name+".txt"))
+	if err != nil {
+		return nil, err
+	}
+	var subject, text strings.Builder
+	if err := txt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := txt.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	msg := &Message{Subject: strings.TrimSpace(subject.String()), Text: strings.TrimSpace(text.String()) + "\n"}
+
+	htmlFile := filepath.Join(mailTemplateDir, name+".html")
+	if _, err := os.Stat(htmlFile); err == nil {
+		tpl, err := template.ParseFiles(htmlFile)
+		if err != nil {
+			return nil, err
+		}
+		var html strings.Builder
+		if err := tpl.Execute(&html, data); err != nil {
+			return nil, err
+		}
+		msg.HTML = html.String()
+	}
+	return msg, nil
+}
+
+// fileMailer writes every message to a .eml file in a directory, for
+// development.
+type fileMailer struct {
+	dir string
+}
+
+func newFileMailer(dir string) (*fileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (f *fileMailer) Send(msg *Message) error {
+	now := time.Now()
+	data, err := msg.Bytes(now)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file, then rename it so readers never see a
+	// partial message
+	id, err := randomName()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".mail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102-150405") + "-" + id[:8] + ".eml"
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, name))
+}
+
+// memoryMailer keeps the messages it is given, for tests.
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *memoryMailer) Send(msg *Message) error {
+	if err := msg.check(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *msg)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (m *memoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message{}, m.sent...)
+}
+
+// logMailer writes messages to the log instead of sending them.
+type logMailer struct{}
+
+func (logMailer) Send(msg *Message) error {
+	if err := msg.check(); err != nil {
+		return err
+	}
+	body := msg.Text
+	if body == "" {
+		body = msg.HTML
+	}
+	log.Printf("mail to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, body)
+	return nil
+}
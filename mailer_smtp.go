@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// smtpTimeout bounds a whole SMTP conversation.
+const smtpTimeout = 30 * time.Second
+
+// smtpConfig describes an SMTP relay.
+type smtpConfig struct {
+	Host string
+	Port string
+	// Username and Password sign in with PLAIN authentication when set.
+	Username string
+	Password string
+	// TLS is "starttls" to upgrade the connection (required), "implicit"
+	// for TLS from the start, as on port 465, or "none".
+	TLS string
+}
+
+// smtpMailer sends mail through an SMTP relay.
+type smtpMailer struct {
+	config smtpConfig
+}
+
+func newSMTPMailer(config smtpConfig) (*smtpMailer, error) {
+	if config.Host == "" {
+		return nil, errors.New("SMTP_HOST is required with MAIL_TRANSPORT=smtp")
+	}
+	switch config.TLS {
+	case "starttls", "implicit", "none":
+	default:
+		return nil, fmt.Errorf("unknown SMTP_TLS %q", config.TLS)
+	}
+	return &smtpMailer{config: config}, nil
+}
+
+func (m *smtpMailer) Send(msg *Message) error {
+	data, err := msg.Bytes(time.Now())
+	if err != nil {
+		return err
+	}
+	from, to, err := msg.envelope()
+	if err != nil {
+		return err
+	}
+
+	// Connect, with TLS from the start if asked
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if m.config.TLS == "implicit" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	// Upgrade the connection; a relay that cannot is not used, so the
+	// password is never sent in the clear
+	if m.config.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	// Send the message
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
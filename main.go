@@ -1,15 +1,15 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
+	"errors"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
-
-	_ "github.com/go-sql-driver/mysql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Database credentials
@@ -19,9 +19,6 @@ const (
 	DBName     = "DB_NAME"
 )
 
-var db *sql.DB
-var tpl *template.Template
-
 type User struct {
 	ID       int
 	Name     string
@@ -31,35 +28,42 @@ type User struct {
 	Role     string // 'admin' or 'user'
 }
 
-type Post struct {
-	ID      int
-	Title   string
-	Content string
-}
+// maxUserFieldLength is the longest name, email or username a user may have.
+const maxUserFieldLength = 255
+
+// usernamePattern is what new usernames must look like.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,32}$`)
 
-type Image struct {
-	URL      string
-	Filename string
+type Post struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	Content     string     `json:"content"`
+	Format      string     `json:"format"`
+	AuthorID    int        `json:"author_id"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+
+	Categories []Category `json:"categories,omitempty"`
+	Tags       []Tag      `json:"tags,omitempty"`
+
+	// HTML is the sanitized rendering of Content, filled in by renderPosts.
+	HTML template.HTML `json:"html,omitempty"`
 }
 
-type ContactEntry struct {
-	ID      int
-	Name    string
-	Email   string
-	Message string
+// OwnerID implements Resource.
+func (p *Post) OwnerID() int {
+	return p.AuthorID
 }
 
-func init() {
-	// Connect to the database
-	connStr := fmt.Sprintf("%s:%s@/%s", getEnv("DB_USERNAME", "root"), getEnv("DB_PASSWORD", ""), getEnv("DB_NAME", "weblat"))
-	var err error
-	db, err = sql.Open("mysql", connStr)
-	if err != nil {
-		log.Fatal(err)
+// cursor returns the position of the post in a listing sorted by sort.
+func (p *Post) cursor(sort string) Cursor {
+	c := Cursor{ID: p.ID}
+	if strings.TrimPrefix(sort, "-") == "title" {
+		c.Value = p.Title
 	}
-
-	// Initialize the template
-	tpl = template.Must(template.ParseGlob("templates/*.html"))
+	return c
 }
 
 func getEnv(key, defaultValue string) string {
@@ -71,33 +75,59 @@ func getEnv(key, defaultValue string) string {
 }
 
 func main() {
-	defer db.Close()
-
-	// Routes
-	http.HandleFunc("/home-usr", indexHandler)
-	http.HandleFunc("/home-adm", homeHandler)
-	http.HandleFunc("/posts", getPostsHandler)
-	http.HandleFunc("/profile", getProfileHandler)
-	http.HandleFunc("/gallery", galleryHandler)
-	http.HandleFunc("/contact", contactHandler)
-	http.HandleFunc("/contact/list", getContactListHandler)
-	http.HandleFunc("/posts-admin", postsHandler)
-	http.HandleFunc("/post/create", createPostHandler)
-	http.HandleFunc("/post/edit", editPostHandler)
-	http.HandleFunc("/post/delete", deletePostHandler)
-	http.HandleFunc("/galery-admin", getImageHandler)
-	http.HandleFunc("/galery/create", uploadImageHandler)
-	http.HandleFunc("/galery/delete", deleteImageHandler)
-
-	http.HandleFunc("/register", registerHandler)
-	http.HandleFunc("/", loginHandler)
-	http.HandleFunc("/logout", logoutHandler)
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "media" {
+		if err := runMediaCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mail" {
+		if err := runMailCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Set up the storage backend
+	repos, closeStorage, err := openStorage(getEnv("DB_DRIVER", "mysql"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeStorage()
+
+	// Initialize the template
+	tpl := template.Must(template.ParseGlob("templates/*.html"))
+
+	srv, err := NewServer(repos, tpl)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go srv.purgeExpiredSessions(time.Hour)
+	go srv.runPostScheduler(time.Minute)
+	if interval, err := time.ParseDuration(getEnv("MEDIA_GC_INTERVAL", "24h")); err != nil || interval <= 0 {
+		log.Println("media reconciliation is disabled")
+	} else {
+		go srv.runMediaGC(interval, getEnv("MEDIA_GC_DELETE_ORPHANS", "false") == "true")
+	}
+	outboxInterval, err := time.ParseDuration(getEnv("MAIL_OUTBOX_INTERVAL", "1m"))
+	if err != nil || outboxInterval <= 0 {
+		log.Fatal("invalid MAIL_OUTBOX_INTERVAL")
+	}
+	go srv.runOutbox(outboxInterval)
 
 	log.Println("Server started on http://localhost:8080")
-	http.ListenAndServe(":8080", nil)
+	http.ListenAndServe(":8080", srv.Handler())
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 	tpl, err := template.ParseFiles("templates/landing.html")
 	if err != nil {
 		log.Println(err)
@@ -112,48 +142,92 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getPostsHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve posts from the database
-	rows, err := db.Query("SELECT * FROM posts")
+func (s *Server) getPostsHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parsePageRequest(r.URL.Query(), postListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve a page of published posts from the database
+	posts, pages, err := s.postPage(r, PostFilter{Status: PostPublished}, req)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content); err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		posts = append(posts, post)
+	// Render the posts
+	s.renderPosts(posts...)
+	tpl, err := template.ParseFiles("templates/posts.html", "templates/pagination.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Posts []*Post
+		Pages *Pagination
+	}{
+		Posts: posts,
+		Pages: pages,
 	}
-	if err := rows.Err(); err != nil {
+	err = tpl.Execute(w, data)
+	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) postPermalinkHandler(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimPrefix(r.URL.Path, "/posts/")
+	if slug == "" {
+		http.Redirect(w, r, "/posts", http.StatusMovedPermanently)
 		return
 	}
 
-	// Render the posts
-	tpl, err := template.ParseFiles("templates/posts.html")
+	// Retrieve the post, following renamed slugs to the current one
+	post, err := s.posts.GetBySlug(slug)
+	if errors.Is(err, ErrNotFound) {
+		postID, rerr := s.posts.SlugRedirect(slug)
+		if rerr == nil {
+			if post, err = s.posts.Get(postID); err == nil && post.Status == PostPublished {
+				http.Redirect(w, r, "/posts/"+post.Slug, http.StatusMovedPermanently)
+				return
+			}
+		} else if !errors.Is(rerr, ErrNotFound) {
+			err = rerr
+		}
+	}
+	if err == nil && post.Status != PostPublished {
+		err = ErrNotFound
+	}
+	if err == nil {
+		err = s.loadPostTerms(post)
+	}
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+
+	// Render the post
+	s.renderPosts(post)
+	tpl, err := template.ParseFiles("templates/post.html")
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	err = tpl.Execute(w, posts)
+	err = tpl.Execute(w, post)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func getProfileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getProfileHandler(w http.ResponseWriter, r *http.Request) {
 	// Define the user data
 	user := User{
 		Name:     "John Doe",
@@ -176,7 +250,7 @@ func getProfileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func galleryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) galleryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Handle form submissions or other POST requests here, if needed
 		// ...
@@ -186,162 +260,122 @@ func galleryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve image URLs from the "gallery" table in the database
-	imageURLs, err := fetchImageURLsFromDatabase()
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	// Pass the image URLs to the template for rendering
-	data := struct {
-		ImageURLs []string
-	}{
-		ImageURLs: imageURLs,
-	}
-
-	// Render the gallery template with the image URLs
-	tpl, err := template.ParseFiles("templates/gallery.html")
+	// Retrieve the albums and the one being viewed, if any
+	albums, err := s.gallery.Albums()
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
-	err = tpl.Execute(w, data)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-	}
-}
-
-func contactHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		// Parse the form data
-		err := r.ParseForm()
-		if err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+	var album *Album
+	if slug := r.URL.Query().Get("album"); slug != "" {
+		for i := range albums {
+			if albums[i].Slug == slug {
+				album = &albums[i]
+			}
 		}
-
-		// Retrieve the form values
-		name := r.Form.Get("name")
-		email := r.Form.Get("email")
-		message := r.Form.Get("message")
-
-		// Insert the form data into the database
-		_, err = db.Exec("INSERT INTO contact_entries (name, email, message) VALUES (?, ?, ?)", name, email, message)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if album == nil {
+			http.NotFound(w, r)
 			return
 		}
-
-		// Redirect or display a success message
-		http.Redirect(w, r, "/success", http.StatusSeeOther)
-		return
 	}
 
-	// Render the contact form template
-	tpl, err := template.ParseFiles("templates/contact.html")
+	list := galleryListing
+	if album != nil {
+		list = albumListing
+	}
+	req, err := parsePageRequest(r.URL.Query(), list)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	err = tpl.Execute(w, nil)
-	if err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Retrieve a page of images from the album or the whole gallery
+	var images []Image
+	var pages *Pagination
+	if album != nil {
+		images, pages, err = s.albumPage(r, album.ID, req)
+	} else {
+		images, pages, err = s.galleryPage(r, req)
 	}
-}
-
-func getContactListHandler(w http.ResponseWriter, r *http.Request) {
-	// Retrieve contact entries from the database
-	rows, err := db.Query("SELECT * FROM contact_entries")
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var contactEntries []ContactEntry
-	for rows.Next() {
-		var entry ContactEntry
-		if err := rows.Scan(&entry.ID, &entry.Name, &entry.Email, &entry.Message); err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		contactEntries = append(contactEntries, entry)
-	}
-	if err := rows.Err(); err != nil {
-		log.Println(err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// Pass the images to the template for rendering
+	data := struct {
+		Albums []Album
+		Album  *Album
+		Images []Image
+		Pages  *Pagination
+	}{
+		Albums: albums,
+		Album:  album,
+		Images: images,
+		Pages:  pages,
 	}
 
-	// Render the contact list template
-	tpl, err := template.ParseFiles("templates/contact_list.html")
+	// Render the gallery template with the images
+	tpl, err := template.ParseFiles("templates/gallery.html", "templates/pagination.html")
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	err = tpl.Execute(w, contactEntries)
+	err = tpl.Execute(w, data)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func fetchImageURLsFromDatabase() ([]string, error) {
-	// Execute a query to fetch image URLs from the "gallery" table
-	rows, err := db.Query("SELECT imageURL FROM gallery")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	// Iterate over the rows and extract the imageURL column
-	var imageURLs []string
-	for rows.Next() {
-		var imageURL string
-		err := rows.Scan(&imageURL)
+func (s *Server) postsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Fetch a page of posts from the database, optionally in a single state
+		status := r.URL.Query().Get("status")
+		if status != "" && !validPostStatus(status) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		req, err := parsePageRequest(r.URL.Query(), postListing)
 		if err != nil {
-			return nil, err
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
 		}
-		imageURLs = append(imageURLs, imageURL)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return imageURLs, nil
-}
-
-func postsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodGet {
-		// Fetch all posts from the database
-		posts, err := fetchPostsFromDatabase()
+		// Authors only list the posts they may edit
+		filter := PostFilter{Status: status}
+		if user := currentUser(r); !s.perms.can(user, PermPostsEditAny, nil) {
+			filter.AuthorID = user.ID
+		}
+		posts, pages, err := s.postPage(r, filter, req)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		data := struct {
+			Posts    []*Post
+			Pages    *Pagination
+			Statuses []string
+			Status   string
+		}{
+			Posts:    posts,
+			Pages:    pages,
+			Statuses: postStatuses,
+			Status:   status,
+		}
+
 		// Render the posts page with the list of posts
-		tpl, err := template.ParseFiles("templates/postsadm.html")
+		tpl, err := template.ParseFiles("templates/postsadm.html", "templates/pagination.html")
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		err = tpl.Execute(w, posts)
+		err = tpl.Execute(w, data)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -350,7 +384,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func createPostHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createPostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// Render the create post form
 		tpl, err := template.ParseFiles("templates/create_post.html")
@@ -359,7 +393,18 @@ func createPostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = tpl.Execute(w, nil)
+		data := postFormData{
+			Post:       &Post{Status: PostDraft, Format: FormatMarkdown},
+			Statuses:   postStatuses,
+			Formats:    contentFormats,
+			CanPublish: s.perms.can(currentUser(r), PermPostsPublish, nil),
+		}
+		if err := s.postFormTerms(&data); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		err = tpl.Execute(w, data)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -367,13 +412,63 @@ func createPostHandler(w http.ResponseWriter, r *http.Request) {
 		// Retrieve the form data
 		title := r.FormValue("title")
 		content := r.FormValue("content")
+		status := r.FormValue("status")
+		if status == "" {
+			status = PostDraft
+		}
+		format := r.FormValue("format")
+		if format == "" {
+			format = FormatMarkdown
+		}
+
+		// Check the form values
+		v := &validator{}
+		validatePost(v, title, content, format)
+		categoryIDs, tagNames, err := s.postTermsFromRequest(r)
+		if errors.Is(err, errUnknownCategory) {
+			v.Check(false, "category", "Choose from the listed categories.")
+		} else if err != nil {
+			s.postTermsError(w, err)
+			return
+		}
+		publishAt, err := parsePublishAt(r.FormValue("publish_at"))
+		v.Check(err == nil, "publish_at", "Enter a valid date and time.")
+
+		// Check the user may put the post in the requested state
+		user := currentUser(r)
+		post := &Post{Title: title, Content: content, Format: format, AuthorID: user.ID, Status: PostDraft}
+		if !s.canSetPostStatus(user, post, status, publishAt) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		checkPostStatus(v, setPostStatus(post, status, publishAt, time.Now()))
+		if !v.Valid() {
+			s.postFormErrors(w, r, "templates/create_post.html", post, v.Errors())
+			return
+		}
+		if err := s.assignSlug(post, r.FormValue("slug")); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 
-		// Save the new post to the database
-		err := savePostToDatabase(title, content)
+		// Save the new post to the database, owned by the current user
+		_, err = s.posts.Create(post)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		if err := s.setPostTerms(post.ID, categoryIDs, tagNames); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := s.recordRevision(post, user.ID, r.FormValue("note")); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		s.indexPost(post)
 
 		// Redirect to the posts page or display a success message
 		http.Redirect(w, r, "/posts", http.StatusSeeOther)
@@ -382,15 +477,19 @@ func createPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func editPostHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) editPostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// Retrieve the post ID from the query parameters
 		postID := r.URL.Query().Get("id")
 
 		// Fetch the post from the database by ID
-		post, err := fetchPostByID(postID)
+		post, err := s.fetchPost(postID)
 		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			s.postLookupError(w, err)
+			return
+		}
+		if !s.perms.can(currentUser(r), PermPostsEdit, post) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
@@ -401,7 +500,22 @@ func editPostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = tpl.Execute(w, post)
+		data := postFormData{
+			Post:       post,
+			Statuses:   postStatuses,
+			Formats:    contentFormats,
+			CanPublish: s.perms.can(currentUser(r), PermPostsPublish, nil),
+		}
+		err = s.loadPostTerms(post)
+		if err == nil {
+			err = s.postFormTerms(&data)
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		err = tpl.Execute(w, data)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
@@ -410,13 +524,76 @@ func editPostHandler(w http.ResponseWriter, r *http.Request) {
 		postID := r.FormValue("id")
 		title := r.FormValue("title")
 		content := r.FormValue("content")
+		status := r.FormValue("status")
+
+		// Check the user may edit this post
+		user := currentUser(r)
+		post, err := s.fetchPost(postID)
+		if err != nil {
+			s.postLookupError(w, err)
+			return
+		}
+		if !s.perms.can(user, PermPostsEdit, post) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		format := r.FormValue("format")
+		if format == "" {
+			format = post.Format
+		}
+
+		// Check the form values
+		v := &validator{}
+		validatePost(v, title, content, format)
+		categoryIDs, tagNames, err := s.postTermsFromRequest(r)
+		if errors.Is(err, errUnknownCategory) {
+			v.Check(false, "category", "Choose from the listed categories.")
+		} else if err != nil {
+			s.postTermsError(w, err)
+			return
+		}
+		publishAt, err := parsePublishAt(r.FormValue("publish_at"))
+		v.Check(err == nil, "publish_at", "Enter a valid date and time.")
+		if status == "" {
+			status = post.Status
+		}
+		if !s.canSetPostStatus(user, post, status, publishAt) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 
 		// Update the post in the database
-		err := updatePostInDatabase(postID, title, content)
+		post.Title = title
+		post.Content = content
+		post.Format = format
+		checkPostStatus(v, setPostStatus(post, status, publishAt, time.Now()))
+		if !v.Valid() {
+			s.postFormErrors(w, r, "templates/edit_post.html", post, v.Errors())
+			return
+		}
+		if err := s.assignSlug(post, r.FormValue("slug")); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		err = s.posts.Update(post)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		if err := s.setPostTerms(post.ID, categoryIDs, tagNames); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Keep a revision of the saved title and content
+		if err := s.recordRevision(post, user.ID, r.FormValue("note")); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		s.indexPost(post)
 
 		// Redirect to the posts page or display a success message
 		http.Redirect(w, r, "/posts", http.StatusSeeOther)
@@ -425,17 +602,29 @@ func editPostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func deletePostHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deletePostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Retrieve the post ID from the form data
 		postID := r.FormValue("id")
 
+		// Check the user may delete this post
+		post, err := s.fetchPost(postID)
+		if err != nil {
+			s.postLookupError(w, err)
+			return
+		}
+		if !s.perms.can(currentUser(r), PermPostsDelete, post) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		// Delete the post from the database
-		err := deletePostFromDatabase(postID)
+		err = s.posts.Delete(post.ID)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		s.unindexPost(post.ID)
 
 		// Redirect to the posts page or display a success message
 		http.Redirect(w, r, "/posts", http.StatusSeeOther)
@@ -444,112 +633,135 @@ func deletePostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func savePostToDatabase(title, content string) error {
-	// Prepare the SQL statement
-	stmt, err := db.Prepare("INSERT INTO posts (title, content) VALUES (?, ?)")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+// postFormData is rendered by create_post.html and edit_post.html.
+type postFormData struct {
+	*Post
+	Statuses   []string
+	Formats    []string
+	CanPublish bool
+
+	// Categories is the category tree offered in the form
+	Categories         []Category
+	SelectedCategories map[int]bool
+	TagNames           string
+
+	// Note and Errors are the change note and the problems of a
+	// submission shown again
+	Note   string
+	Errors ValidationErrors
+}
 
-	// Execute the SQL statement
-	_, err = stmt.Exec(title, content)
-	if err != nil {
-		return err
-	}
+// maxPostTitleLength is the longest title a post may have.
+const maxPostTitleLength = 255
 
-	return nil
+// validatePost checks the title, content and format of a post.
+func validatePost(v *validator, title, content, format string) {
+	v.Required("title", title)
+	v.MaxLength("title", title, maxPostTitleLength)
+	v.Required("content", content)
+	v.Check(validContentFormat(format), "format", "Choose plain, markdown or html.")
 }
 
-func fetchPostsFromDatabase() ([]*Post, error) {
-	// TODO: Implement the logic to fetch posts from the database
-	// Prepare the SQL statement
-	rows, err := db.Query("SELECT * FROM posts")
-	if err != nil {
-		return nil, err
+// checkPostStatus records the field at fault for an error from
+// setPostStatus.
+func checkPostStatus(v *validator, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errPublishAtRequired):
+		v.Check(false, "publish_at", "Scheduled posts need a publish time.")
+	default:
+		v.Check(false, "status", "Choose one of the listed statuses.")
 	}
-	defer rows.Close()
-
-	// Create a slice to hold the retrieved posts
-	posts := make([]*Post, 0)
-
-	// Iterate over the rows
-	for rows.Next() {
-		// Create a new Post struct
-		post := &Post{}
-
-		// Scan the row values into the Post struct
-		err := rows.Scan(&post.ID, &post.Title, &post.Content)
-		if err != nil {
-			return nil, err
-		}
+}
 
-		// Append the post to the slice
-		posts = append(posts, post)
+// postFormErrors answers a post form submission that did not pass
+// validation, rendering the form again with post, which holds the values
+// sent, and the status, slug and terms requested.
+func (s *Server) postFormErrors(w http.ResponseWriter, r *http.Request, name string, post *Post, errs ValidationErrors) {
+	if wantsJSON(r) {
+		writeValidationErrors(w, errs)
+		return
 	}
 
-	// Check for any errors during iteration
-	err = rows.Err()
+	tpl, err := template.ParseFiles(name)
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	return posts, nil
-}
-
-func fetchPostByID(postID string) (*Post, error) {
-	// Prepare the SQL statement
-	stmt, err := db.Prepare("SELECT id, title, content FROM posts WHERE id = ?")
-	if err != nil {
-		return nil, err
+	// Show what was asked for rather than what the post has
+	if status := r.FormValue("status"); status != "" {
+		post.Status = status
+	}
+	if publishAt, err := parsePublishAt(r.FormValue("publish_at")); err == nil && publishAt != nil {
+		post.PublishAt = publishAt
 	}
-	defer stmt.Close()
+	if slug := r.FormValue("slug"); slug != "" {
+		post.Slug = slug
+	}
+	data := postFormData{
+		Post:       post,
+		Statuses:   postStatuses,
+		Formats:    contentFormats,
+		CanPublish: s.perms.can(currentUser(r), PermPostsPublish, nil),
+		Errors:     errs,
+	}
+	if err := s.postFormTerms(&data); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data.SelectedCategories = make(map[int]bool)
+	for _, v := range r.Form["category"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			data.SelectedCategories[id] = true
+		}
+	}
+	data.TagNames = r.Form.Get("tags")
+	data.Note = r.Form.Get("note")
 
-	// Execute the SQL statement and retrieve the post
-	var post Post
-	err = stmt.QueryRow(postID).Scan(&post.ID, &post.Title, &post.Content)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	err = tpl.Execute(w, data)
 	if err != nil {
-		return nil, err
+		log.Println(err)
 	}
-
-	return &post, nil
 }
 
-func updatePostInDatabase(postID, title, content string) error {
-	// Prepare the SQL statement
-	stmt, err := db.Prepare("UPDATE posts SET title = ?, content = ? WHERE id = ?")
+// postFormTerms fills in the taxonomy widgets of the post forms.
+func (s *Server) postFormTerms(data *postFormData) error {
+	categories, err := s.taxonomy.Categories()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	// Execute the SQL statement
-	_, err = stmt.Exec(title, content, postID)
-	if err != nil {
-		return err
+	data.Categories = categoryTree(categories)
+	data.SelectedCategories = make(map[int]bool)
+	for _, c := range data.Post.Categories {
+		data.SelectedCategories[c.ID] = true
 	}
-
+	data.TagNames = joinTagNames(data.Post.Tags)
 	return nil
 }
 
-func deletePostFromDatabase(postID string) error {
-	// Prepare the SQL statement
-	stmt, err := db.Prepare("DELETE FROM posts WHERE id = ?")
+// fetchPost looks up a post by the ID given in a form or query parameter.
+func (s *Server) fetchPost(postID string) (*Post, error) {
+	id, err := strconv.Atoi(postID)
 	if err != nil {
-		return err
+		return nil, ErrNotFound
 	}
-	defer stmt.Close()
+	return s.posts.Get(id)
+}
 
-	// Execute the SQL statement
-	_, err = stmt.Exec(postID)
-	if err != nil {
-		return err
+func (s *Server) postLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
 	}
-
-	return nil
+	log.Println(err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
 
-func getImageHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Handle form submissions or other POST requests here, if needed
 		// ...
@@ -559,24 +771,43 @@ func getImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Retrieve image URLs from the "gallery" table in the database
-	imageURLs, err := fetchImageURLsFromDatabase()
+	req, err := parsePageRequest(r.URL.Query(), galleryListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve a page of images from the "gallery" table in the database
+	images, pages, err := s.galleryPage(r, req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	albums, err := s.gallery.Albums()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	items, err := s.imageAdminItems(images, albums)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Pass the image URLs to the template for rendering
+	// Pass the images to the template for rendering
 	data := struct {
-		ImageURLs []string
+		Images []imageAdminItem
+		Pages  *Pagination
 	}{
-		ImageURLs: imageURLs,
+		Images: items,
+		Pages:  pages,
 	}
-	fmt.Println(imageURLs)
 
 	// Render the gallery template with the image URLs
-	tpl, err := template.ParseFiles("templates/galeryadm.html")
+	tpl, err := template.ParseFiles("templates/galeryadm.html", "templates/pagination.html")
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -590,27 +821,24 @@ func getImageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		// Retrieve the image URL from the form data
-		imageURL := r.FormValue("imageURL")
+		// Retrieve the image ID from the form data
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
 
-		// Delete the image from the "gallery" table in the database
-		_, err := db.Exec("DELETE FROM gallery WHERE imageURL = ?", imageURL)
+		// Move the image to the trash; its files are deleted once the
+		// trash period is over
+		err = s.gallery.Trash(id, time.Now().UTC())
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-
-		// Delete the image file from the "uploads" directory
-		// filename := imageURL[len("/uploads/"):]
-		// err = os.Remove("./uploads/" + filename)
-		// if err != nil {
-		// 	log.Println(err)
-		// 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		// 	return
-		// }
+		s.unindexImage(id)
 
 		// Redirect or display a success message
 		http.Redirect(w, r, "/gallery", http.StatusSeeOther)
@@ -620,53 +848,62 @@ func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 }
 
-func uploadImageHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) uploadImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
+		// Limit the request to the upload size
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes+uploadFormOverhead)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			var maxErr *http.MaxBytesError
+			if !errors.As(err, &maxErr) {
+				err = &uploadError{http.StatusBadRequest, "invalid_form", "The upload form could not be read"}
+			}
+			writeUploadError(w, r, err)
+			return
+		}
+
 		// Retrieve the uploaded file
 		file, handler, err := r.FormFile("file")
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeUploadError(w, r, &uploadError{http.StatusBadRequest, "missing_file", "Choose a file to upload"})
 			return
 		}
 		defer file.Close()
 
-		// Save the uploaded file with a unique filename
-		filename := handler.Filename
-		f, err := os.OpenFile("uploads/"+filename, os.O_WRONLY|os.O_CREATE, 0666)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Describe the image
+		img := &Image{CreatedAt: time.Now()}
+		if user := currentUser(r); user != nil {
+			img.UploaderID = user.ID
 		}
-		defer f.Close()
-		io.Copy(f, file)
-
-		// Update the profile picture URL in the database
-		imageURL := "uploads/" + filename
-		_, err = db.Exec("INSERT INTO gallery (imageURL) VALUES (?)", imageURL)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		var ok bool
+		img.AltText, img.Caption, ok = imageText(r)
+		if !ok {
+			writeUploadError(w, r, &uploadError{http.StatusBadRequest, "invalid_text", "Alt text or caption is too long"})
 			return
 		}
 
-		// Render the index page with the updated image gallery
-		imageURLs, err := fetchImageURLsFromDatabase()
+		// Check and store the file under a generated name
+		data, err := s.storeUpload(file, handler, img)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeUploadError(w, r, err)
 			return
 		}
 
-		tpl, err := template.ParseFiles("templates/index.html")
-		if err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		// Add the image to the "gallery" table in the database
+		if _, err := s.gallery.Add(img); err != nil {
+			s.media.Delete(img.Path)
+			writeUploadError(w, r, err)
 			return
 		}
+		s.indexImage(img)
 
-		err = tpl.Execute(w, imageURLs)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		// Make the resized copies; the upload stands without them
+		s.deriveImage(img, data)
+
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusCreated, apiItem{Data: img})
+			return
 		}
+		http.Redirect(w, r, "/galery-admin", http.StatusSeeOther)
 		return
 	}
 
@@ -678,14 +915,19 @@ func uploadImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = tpl.Execute(w, nil)
+	data := struct {
+		MaxSize string
+	}{
+		MaxSize: Image{Size: s.maxUploadBytes}.HumanSize(),
+	}
+	err = tpl.Execute(w, data)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
 	tpl, err := template.ParseFiles("templates/dashboard.html")
 	if err != nil {
 		log.Println(err)
@@ -700,146 +942,222 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		// Get the form values
-		name := r.FormValue("name")
-		email := r.FormValue("email")
-		username := r.FormValue("username")
+		name := strings.TrimSpace(r.FormValue("name"))
+		email := strings.TrimSpace(r.FormValue("email"))
+		username := strings.TrimSpace(r.FormValue("username"))
 		password := r.FormValue("password")
 
-		// Create a new user
-		user := User{
-			Name:     name,
-			Email:    email,
-			Username: username,
-			Password: password,
-			Role:     "user",
+		// Check the form values, and show the form again with the
+		// problems found
+		v := &validator{}
+		v.Required("name", name)
+		v.MaxLength("name", name, maxUserFieldLength)
+		v.Required("email", email)
+		v.Email("email", email)
+		v.MaxLength("email", email, maxUserFieldLength)
+		v.Required("username", username)
+		v.Matches("username", username, usernamePattern, "Use 3 to 32 letters, digits, dots, dashes or underscores.")
+		v.Required("password", password)
+		v.MinLength("password", password, minPasswordLength)
+		v.Check(len(password) <= maxPasswordBytes, "password", "Use a shorter password.")
+		if !v.Valid() {
+			s.registerFormErrors(w, r, v.Errors())
+			return
 		}
 
-		// Save the user to the database
-		stmt, err := db.Prepare("INSERT INTO users (name, username, email, password, role) VALUES (?, ?, ?, ?, ?)")
+		// Keep out spam; the password is left out of the spam queue. Only
+		// valid submissions are screened, so mistakes do not count towards
+		// the rate limit
+		sub := &SpamSubmission{Form: SpamFormRegister, Fields: map[string]string{"name": name, "email": email, "username": username}}
+		if !s.screenSubmission(w, r, sub) {
+			return
+		}
+
+		// Check the username is free only now, so the rate limit also
+		// slows down probing for taken names
+		err := v.Unique("username", func() (bool, error) { return s.usernameTaken(username) }, "This username is already taken.")
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		defer stmt.Close()
+		if !v.Valid() {
+			s.registerFormErrors(w, r, v.Errors())
+			return
+		}
 
-		_, err = stmt.Exec(user.Name, user.Username, user.Email, user.Password, user.Role)
+		// Hash the password before it is stored
+		hash, err := hashPassword(password)
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		// Create a new user
+		user := User{
+			Name:     name,
+			Email:    email,
+			Username: username,
+			Password: hash,
+			Role:     RoleUser,
+		}
+
+		// Save the user to the database
+		_, err = s.users.Create(&user)
+		if err != nil {
+			// Someone may have taken the username since it was checked
+			if taken, terr := s.usernameTaken(username); terr == nil && taken {
+				s.registerFormErrors(w, r, ValidationErrors{{Field: "username", Message: "This username is already taken."}})
+				return
+			}
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		// Redirect to the login page
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	err := tpl.ExecuteTemplate(w, "register.html", nil)
+	err := s.tpl.ExecuteTemplate(w, "register.html", publicForm{spamForm: s.spamFormData(SpamFormRegister)})
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+// registerFormErrors answers a registration that did not pass validation,
+// rendering the form again with the values sent, except the password.
+func (s *Server) registerFormErrors(w http.ResponseWriter, r *http.Request, errs ValidationErrors) {
+	if wantsJSON(r) {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	data := publicForm{
+		spamForm:  s.spamFormData(SpamFormRegister),
+		formState: formState{Values: r.PostForm, Errors: errs},
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	err := s.tpl.ExecuteTemplate(w, "register.html", data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// usernameTaken reports whether a user already has the username.
+func (s *Server) usernameTaken(username string) (bool, error) {
+	_, err := s.users.GetByUsername(username)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
 		// Fetch the user from the database
-		user, err := fetchUserByUsername(username, db)
+		user, err := s.fetchUserByUsername(username)
 		if err != nil {
 			http.Error(w, "Error fetching user", http.StatusInternalServerError)
 			return
 		}
 
-		// Check if the user exists and password is correct
-		if user == nil || user.Password != password {
+		// Check if the user exists and password is correct. Unknown
+		// users cost a hash check too, so timing does not tell them apart
+		if user == nil {
+			checkPassword(dummyPasswordHash, password)
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		ok, needsRehash := checkPassword(user.Password, password)
+		if !ok {
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 
+		// Upgrade legacy plaintext or weaker hashes in place
+		if needsRehash {
+			if hash, err := hashPassword(password); err != nil {
+				log.Println(err)
+			} else if err := s.users.UpdatePassword(user.ID, hash); err != nil {
+				log.Println(err)
+			}
+		}
+
+		// Issue a new server-side session for the user
+		if _, err := s.startSession(w, r, user); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		// If login is successful, redirect to appropriate pages based on user role
-		if user.Role == "admin" {
-			http.Redirect(w, r, "/home-adm", http.StatusFound)
-		} else if user.Role == "user" {
-			http.Redirect(w, r, "/home-usr", http.StatusFound)
-		} else {
+		home, ok := s.homePathForRole(user.Role)
+		if !ok {
 			http.Error(w, "Invalid user role", http.StatusInternalServerError)
+			return
 		}
+		http.Redirect(w, r, home, http.StatusFound)
 	} else {
 		// Display the login form
-		tpl.ExecuteTemplate(w, "login.html", nil)
+		s.tpl.ExecuteTemplate(w, "login.html", nil)
 	}
 }
 
-func fetchUserByUsername(username string, db *sql.DB) (*User, error) {
-	query := "SELECT id, name, email, username, password, role FROM users WHERE username = ?"
-	row := db.QueryRow(query, username)
-
-	var user User
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Username, &user.Password, &user.Role)
+func (s *Server) fetchUserByUsername(username string) (*User, error) {
+	user, err := s.users.GetByUsername(username)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == ErrNotFound {
 			// User not found
 			return nil, nil
 		}
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear the session cookie to log out the user
-	cookie := &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Revoke the server-side session and clear the cookie
+	if err := s.endSession(w, r); err != nil {
+		log.Println(err)
 	}
-	http.SetCookie(w, cookie)
 	// Redirect to the login page or any other desired page
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func validateCredentials(username, password string) bool {
-	// Query the database for the user with the given username and password
-	row := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ? AND password = ?", username, password)
-	var count int
-	err := row.Scan(&count)
+func (s *Server) validateCredentials(username, password string) bool {
+	// Query the database for the user with the given username
+	user, err := s.fetchUserByUsername(username)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
+	if user == nil {
+		return false
+	}
 
-	// Return true if a user record with the provided username and password exists, otherwise return false
-	return count > 0
+	// Return true if the password matches the stored hash, otherwise return false
+	ok, _ := checkPassword(user.Password, password)
+	return ok
 }
 
-func authenticationMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if the user is authenticated
-		authenticated := checkAuthentication(r)
-		if !authenticated {
-			// If not authenticated, redirect to the login page or display an error message
-			http.Redirect(w, r, "/login", http.StatusFound)
-			return
-		}
-		// If authenticated, call the next handler
-		next.ServeHTTP(w, r)
-	})
+func (s *Server) authenticationMiddleware(next http.Handler) http.Handler {
+	// Any signed-in user is accepted, whatever their role
+	return s.requireRoles()(next)
 }
 
-func checkAuthentication(r *http.Request) bool {
-	// Check if the session cookie is present and contains the authenticated value
-	cookie, err := r.Cookie("session")
-	if err != nil || cookie.Value != "authenticated" {
-		return false
-	}
-	return true
+func (s *Server) checkAuthentication(r *http.Request) bool {
+	// Check if the session cookie refers to a live server-side session
+	_, err := s.currentSession(r)
+	return err == nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Post content formats
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+var contentFormats = []string{FormatPlain, FormatMarkdown, FormatHTML}
+
+func validContentFormat(format string) bool {
+	for _, f := range contentFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// renderCacheSize is the number of rendered documents kept in memory.
+const renderCacheSize = 512
+
+// contentRenderer turns post content into sanitized HTML. Rendered output is
+// cached by format and content, so edits never serve stale HTML.
+type contentRenderer struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+	strict   *bluemonday.Policy
+
+	mu    sync.Mutex
+	order *list.List
+	cache map[[sha256.Size]byte]*list.Element
+}
+
+type renderCacheEntry struct {
+	key  [sha256.Size]byte
+	html template.HTML
+}
+
+func newContentRenderer() *contentRenderer {
+	// Start from the user generated content allowlist and keep the
+	// language class goldmark puts on fenced code blocks
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w-]+$`)).OnElements("code")
+
+	// Strip every tag for plain text, keeping words in separate blocks apart
+	strict := bluemonday.StrictPolicy()
+	strict.AddSpaceWhenStrippingTag(true)
+
+	return &contentRenderer{
+		markdown: goldmark.New(goldmark.WithExtensions(extension.GFM)),
+		policy:   policy,
+		strict:   strict,
+		order:    list.New(),
+		cache:    make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// Render returns content in the given format as sanitized HTML.
+func (c *contentRenderer) Render(format, content string) template.HTML {
+	key := sha256.Sum256([]byte(format + "\x00" + content))
+
+	c.mu.Lock()
+	if el, ok := c.cache[key]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*renderCacheEntry).html
+	}
+	c.mu.Unlock()
+
+	out := template.HTML(c.policy.Sanitize(c.toHTML(format, content)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[key]; !ok {
+		c.cache[key] = c.order.PushFront(&renderCacheEntry{key: key, html: out})
+		if c.order.Len() > renderCacheSize {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.cache, oldest.Value.(*renderCacheEntry).key)
+		}
+	}
+	return out
+}
+
+// PlainText returns content in the given format with all markup removed,
+// for indexing and excerpts.
+func (c *contentRenderer) PlainText(format, content string) string {
+	if format == FormatPlain {
+		return content
+	}
+	text := html.UnescapeString(c.strict.Sanitize(c.toHTML(format, content)))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// toHTML converts content to unsanitized HTML.
+func (c *contentRenderer) toHTML(format, content string) string {
+	switch format {
+	case FormatMarkdown:
+		var buf bytes.Buffer
+		if err := c.markdown.Convert([]byte(content), &buf); err == nil {
+			return buf.String()
+		}
+	case FormatHTML:
+		return content
+	}
+	return plainToHTML(content)
+}
+
+var blankLineRe = regexp.MustCompile(`\n\s*\n`)
+
+// plainToHTML escapes text and keeps its paragraphs and line breaks.
+func plainToHTML(text string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, para := range blankLineRe.Split(text, -1) {
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(template.HTMLEscapeString(strings.TrimSpace(para)), "\n", "<br>\n"))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
+
+// renderPosts fills in the rendered HTML of each post.
+func (s *Server) renderPosts(posts ...*Post) {
+	for _, post := range posts {
+		post.HTML = s.renderer.Render(post.Format, post.Content)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image is a file in the media library.
+type Image struct {
+	ID int `json:"id"`
+	// Filename is the name the file was uploaded with.
+	Filename string `json:"filename"`
+	// Path is the key of the file in the media store.
+	Path       string    `json:"path"`
+	MIMEType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Checksum   string    `json:"checksum"` // hex SHA-256 of the file
+	UploaderID int       `json:"uploader_id"`
+	AltText    string    `json:"alt_text"`
+	Caption    string    `json:"caption"`
+	CreatedAt  time.Time `json:"created_at"`
+	// DeletedAt is set while the image is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// FileMissing is set when the last reconciliation found no file for
+	// the image.
+	FileMissing bool `json:"file_missing,omitempty"`
+	// Derivatives are the resized copies, loaded on request.
+	Derivatives []Derivative `json:"derivatives,omitempty"`
+}
+
+// Alt returns the text for the image's alt attribute.
+func (img Image) Alt() string {
+	if img.AltText != "" {
+		return img.AltText
+	}
+	return img.Filename
+}
+
+// HumanSize returns the file size for display.
+func (img Image) HumanSize() string {
+	switch {
+	case img.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(img.Size)/(1<<20))
+	case img.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(img.Size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", img.Size)
+}
+
+// Album is an ordered selection of library images. Albums are listed by
+// Position, then by name.
+type Album struct {
+	ID       int
+	Name     string
+	Slug     string
+	Position int
+}
+
+// Caption and alt text limits, matching the gallery columns.
+const (
+	maxAltTextLength = 255
+	maxCaptionLength = 1000
+)
+
+// readImageInfo fills in the MIME type, size, dimensions and checksum of
+// the file and rewinds it. Dimensions stay zero for formats that cannot be
+// decoded.
+func readImageInfo(f io.ReadSeeker, img *Image) error {
+	// Sniff the type from the first bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	img.MIMEType = http.DetectContentType(head[:n])
+
+	// Read the dimensions from the header
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if cfg, _, err := image.DecodeConfig(f); err == nil {
+		img.Width, img.Height = cfg.Width, cfg.Height
+	}
+
+	// Hash the whole file
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	img.Size, err = io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	img.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// imageText returns the trimmed alt text and caption from the form, or false
+// when one is too long.
+func imageText(r *http.Request) (string, string, bool) {
+	alt := strings.TrimSpace(r.FormValue("alt_text"))
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	ok := len([]rune(alt)) <= maxAltTextLength && len([]rune(caption)) <= maxCaptionLength
+	return alt, caption, ok
+}
+
+// formAlbumIDs returns the albums checked in the form, all of which must
+// exist.
+func formAlbumIDs(r *http.Request, albums []Album) ([]int, bool) {
+	var ids []int
+	for _, v := range r.Form["album"] {
+		id, err := strconv.Atoi(v)
+		if err != nil || findAlbum(albums, id) == nil {
+			return nil, false
+		}
+		if !containsID(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, true
+}
+
+func findAlbum(albums []Album, id int) *Album {
+	for i := range albums {
+		if albums[i].ID == id {
+			return &albums[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) albumSlugOwner(slug string) (int, error) {
+	a, err := s.gallery.GetAlbumBySlug(slug)
+	if err != nil {
+		return 0, err
+	}
+	return a.ID, nil
+}
+
+// updateImageHandler saves the alt text, caption and albums of an image.
+// Images in the trash are not found, so an edit cannot put them back in
+// the search index.
+func (s *Server) updateImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	img, err := s.gallery.Get(id)
+	if errors.Is(err, ErrNotFound) || (err == nil && img.DeletedAt != nil) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Retrieve the form data
+	var ok bool
+	img.AltText, img.Caption, ok = imageText(r)
+	if !ok {
+		http.Error(w, "Alt text or caption is too long", http.StatusBadRequest)
+		return
+	}
+	albums, err := s.gallery.Albums()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	albumIDs, ok := formAlbumIDs(r, albums)
+	if !ok {
+		http.Error(w, "Unknown album", http.StatusBadRequest)
+		return
+	}
+
+	// Save the image
+	err = s.gallery.Update(img)
+	if err == nil {
+		err = s.gallery.SetImageAlbums(img.ID, albumIDs)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.indexImage(img)
+
+	http.Redirect(w, r, "/galery-admin", http.StatusSeeOther)
+}
+
+// imageAdminItem is an image on the gallery admin page with the albums
+// it may be put in.
+type imageAdminItem struct {
+	Image
+	Albums []albumChoice
+}
+
+type albumChoice struct {
+	Album
+	Checked bool
+}
+
+func (s *Server) imageAdminItems(images []Image, albums []Album) ([]imageAdminItem, error) {
+	items := make([]imageAdminItem, len(images))
+	for i, img := range images {
+		ids, err := s.gallery.ImageAlbums(img.ID)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = imageAdminItem{Image: img, Albums: make([]albumChoice, len(albums))}
+		for j, a := range albums {
+			items[i].Albums[j] = albumChoice{Album: a, Checked: containsID(ids, a.ID)}
+		}
+	}
+	return items, nil
+}
+
+type albumAdminData struct {
+	Album
+	Count int
+}
+
+// adminAlbumsHandler lists the albums and creates, updates and deletes them.
+func (s *Server) adminAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Retrieve the form data
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		position, _ := strconv.Atoi(r.FormValue("position"))
+		name := strings.TrimSpace(r.FormValue("name"))
+		if r.FormValue("action") == "create" {
+			id = 0
+		}
+
+		var err error
+		switch r.FormValue("action") {
+		case "delete":
+			err = s.gallery.DeleteAlbum(id)
+		case "create", "update":
+			if name == "" {
+				http.Error(w, "Name is required", http.StatusBadRequest)
+				return
+			}
+
+			a := &Album{ID: id, Name: name, Position: position}
+			slug := r.FormValue("slug")
+			if slug == "" {
+				slug = name
+			}
+			a.Slug, err = uniqueTermSlug(slug, id, s.albumSlugOwner)
+			if err == nil {
+				if id == 0 {
+					_, err = s.gallery.CreateAlbum(a)
+				} else {
+					err = s.gallery.UpdateAlbum(a)
+				}
+			}
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/galery/albums", http.StatusSeeOther)
+		return
+	}
+
+	// Retrieve the albums and their sizes
+	albums, err := s.gallery.Albums()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data := make([]albumAdminData, len(albums))
+	for i, a := range albums {
+		_, count, err := s.gallery.AlbumPage(a.ID, PageQuery{})
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data[i] = albumAdminData{Album: a, Count: count}
+	}
+
+	// Render the album management page
+	tpl, err := template.ParseFiles("templates/albums.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// arrangeAlbumHandler shows the images of an album and saves their order.
+// The form posts a position for every image; images are sorted by it.
+func (s *Server) arrangeAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	album, err := s.gallery.GetAlbum(id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	images, err := s.gallery.AlbumImages(album.ID)
+	if err == nil {
+		err = s.loadDerivatives(images)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// Sort the images by their new positions, keeping the current
+		// order for equal ones
+		positions := make(map[int]int, len(images))
+		for i, img := range images {
+			p, err := strconv.Atoi(r.FormValue("position-" + strconv.Itoa(img.ID)))
+			if err != nil {
+				p = i + 1
+			}
+			positions[img.ID] = p
+		}
+		sort.SliceStable(images, func(i, j int) bool {
+			return positions[images[i].ID] < positions[images[j].ID]
+		})
+
+		ids := make([]int, len(images))
+		for i, img := range images {
+			ids[i] = img.ID
+		}
+		if err := s.gallery.OrderAlbum(album.ID, ids); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/galery/album?id="+strconv.Itoa(album.ID), http.StatusSeeOther)
+		return
+	}
+
+	// Render the arrange page
+	tpl, err := template.ParseFiles("templates/album.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	type albumImage struct {
+		Image
+		Position int
+	}
+	data := struct {
+		Album  *Album
+		Images []albumImage
+	}{
+		Album: album,
+	}
+	for i, img := range images {
+		data.Images = append(data.Images, albumImage{Image: img, Position: i + 1})
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultTrashDays is how long deleted images stay in the trash when
+// MEDIA_TRASH_DAYS is not set.
+const defaultTrashDays = 30
+
+// orphanGracePeriod protects files that were stored moments ago and are
+// not recorded yet, such as an upload still being saved.
+const orphanGracePeriod = time.Hour
+
+// mediaTrashPeriod returns how long deleted images are kept in the trash.
+func mediaTrashPeriod() time.Duration {
+	v := getEnv("MEDIA_TRASH_DAYS", "")
+	if v == "" {
+		return defaultTrashDays * 24 * time.Hour
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 0 {
+		log.Printf("invalid MEDIA_TRASH_DAYS %q, using %d", v, defaultTrashDays)
+		days = defaultTrashDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// reconcileOptions selects what a reconciliation may delete. Without them
+// it only reports.
+type reconcileOptions struct {
+	// DeleteOrphans deletes files no image refers to.
+	DeleteOrphans bool
+	// PurgeTrash deletes the images kept in the trash past the trash
+	// period, with their files.
+	PurgeTrash bool
+}
+
+// missingFile is a file an image refers to that is not in the media store.
+type missingFile struct {
+	ImageID int
+	Key     string
+}
+
+// reconcileReport is the outcome of a reconciliation.
+type reconcileReport struct {
+	Purged  []int
+	Orphans []BlobInfo
+	// DeletedOrphans counts the orphans that were deleted. Orphans newer
+	// than orphanGracePeriod are never deleted.
+	DeletedOrphans int
+	Missing        []missingFile
+}
+
+func (r *reconcileReport) String() string {
+	return fmt.Sprintf("purged %d trashed image(s), found %d orphaned file(s) (%d deleted) and %d missing file(s)",
+		len(r.Purged), len(r.Orphans), r.DeletedOrphans, len(r.Missing))
+}
+
+// reconcileMedia compares the media library with the media store. It
+// empties the trash of expired images, flags images whose file is missing
+// and reports, or deletes, the files no image refers to.
+func (s *Server) reconcileMedia(opts reconcileOptions, now time.Time) (*reconcileReport, error) {
+	report := &reconcileReport{}
+
+	// Empty the trash of images kept past the trash period
+	if opts.PurgeTrash {
+		trashed, err := s.gallery.Trashed()
+		if err != nil {
+			return nil, err
+		}
+		for i := range trashed {
+			if now.Sub(*trashed[i].DeletedAt) < s.trashPeriod {
+				continue
+			}
+			if err := s.purgeImage(&trashed[i]); err != nil {
+				return nil, err
+			}
+			report.Purged = append(report.Purged, trashed[i].ID)
+		}
+	}
+
+	// List the images before the files, so a file stored in between is
+	// at worst a recent orphan
+	live, err := s.gallery.List()
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := s.gallery.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := s.media.List("")
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		stored[b.Key] = true
+	}
+
+	// Check the files of every image, flagging those without an original
+	referenced := make(map[string]bool)
+	for _, img := range append(live, trashed...) {
+		derivatives, err := s.gallery.Derivatives(img.ID)
+		if err != nil {
+			return nil, err
+		}
+		referenced[img.Path] = true
+		missing := !stored[img.Path]
+		if missing {
+			report.Missing = append(report.Missing, missingFile{ImageID: img.ID, Key: img.Path})
+		}
+		if missing != img.FileMissing {
+			if err := s.gallery.MarkMissing(img.ID, missing); err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range derivatives {
+			referenced[d.Path] = true
+			if !stored[d.Path] {
+				report.Missing = append(report.Missing, missingFile{ImageID: img.ID, Key: d.Path})
+			}
+		}
+	}
+
+	// Report the files nothing refers to, deleting the older ones on request
+	for _, b := range blobs {
+		if referenced[b.Key] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, b)
+		if opts.DeleteOrphans && now.Sub(b.ModTime) >= orphanGracePeriod {
+			if err := s.media.Delete(b.Key); err != nil {
+				return nil, err
+			}
+			report.DeletedOrphans++
+		}
+	}
+	return report, nil
+}
+
+// purgeImage deletes an image for good. The record goes first: a file
+// left behind by a failure is an orphan the next reconciliation removes.
+func (s *Server) purgeImage(img *Image) error {
+	derivatives, err := s.gallery.Derivatives(img.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.gallery.Delete(img.ID); err != nil {
+		return err
+	}
+	s.unindexImage(img.ID)
+
+	for _, key := range append([]string{img.Path}, derivativePaths(derivatives)...) {
+		if err := s.media.Delete(key); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+func derivativePaths(derivatives []Derivative) []string {
+	paths := make([]string, len(derivatives))
+	for i, d := range derivatives {
+		paths[i] = d.Path
+	}
+	return paths
+}
+
+// runMediaGC reconciles the media library every interval, emptying the
+// trash and, when deleteOrphans is set, deleting orphaned files.
+func (s *Server) runMediaGC(interval time.Duration, deleteOrphans bool) {
+	for range time.Tick(interval) {
+		report, err := s.reconcileMedia(reconcileOptions{DeleteOrphans: deleteOrphans, PurgeTrash: true}, time.Now().UTC())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(report.Purged) > 0 || len(report.Orphans) > 0 || len(report.Missing) > 0 {
+			log.Println("media:", report)
+		}
+	}
+}
+
+// runMediaCommand runs the media subcommand:
+//
+//	media reconcile [-delete-orphans] [-purge]
+//
+// It lists the missing and orphaned files and the purged images.
+func runMediaCommand(args []string) error {
+	const usage = "usage: media reconcile [-delete-orphans] [-purge]"
+	if len(args) == 0 || args[0] != "reconcile" {
+		return errors.New(usage)
+	}
+	flags := flag.NewFlagSet("media reconcile", flag.ContinueOnError)
+	deleteOrphans := flags.Bool("delete-orphans", false, "delete files no image refers to")
+	purge := flags.Bool("purge", false, "delete the images kept in the trash past the trash period")
+	if err := flags.Parse(args[1:]); err != nil {
+		return errors.New(usage)
+	}
+
+	repos, closeStorage, err := openStorage(getEnv("DB_DRIVER", "mysql"))
+	if err != nil {
+		return err
+	}
+	defer closeStorage()
+	media, err := newBlobStore()
+	if err != nil {
+		return err
+	}
+	s := &Server{gallery: repos.Gallery, search: repos.Search, media: media, trashPeriod: mediaTrashPeriod()}
+
+	report, err := s.reconcileMedia(reconcileOptions{DeleteOrphans: *deleteOrphans, PurgeTrash: *purge}, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, id := range report.Purged {
+		fmt.Printf("purged   image %d\n", id)
+	}
+	for _, m := range report.Missing {
+		fmt.Printf("missing  %s (image %d)\n", m.Key, m.ImageID)
+	}
+	for _, b := range report.Orphans {
+		fmt.Printf("orphaned %s (%s, %s)\n", b.Key, Image{Size: b.Size}.HumanSize(), b.ModTime.Format("2006-01-02 15:04"))
+	}
+	fmt.Println(report)
+	return nil
+}
+
+// trashItem is an image on the trash page.
+type trashItem struct {
+	Image
+	// PurgeAt is when the image will be deleted for good.
+	PurgeAt time.Time
+}
+
+// trashHandler lists the images in the trash, and restores them or deletes
+// them for good.
+func (s *Server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Retrieve the image
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		img, err := s.gallery.Get(id)
+		if errors.Is(err, ErrNotFound) || (err == nil && img.DeletedAt == nil) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.FormValue("action") {
+		case "restore":
+			err = s.gallery.Restore(img.ID)
+			if err == nil {
+				img.DeletedAt = nil
+				s.indexImage(img)
+			}
+		case "delete":
+			err = s.purgeImage(img)
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/galery/trash", http.StatusSeeOther)
+		return
+	}
+
+	// Retrieve the trashed images
+	images, err := s.gallery.Trashed()
+	if err == nil {
+		err = s.loadDerivatives(images)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	items := make([]trashItem, len(images))
+	for i, img := range images {
+		items[i] = trashItem{Image: img, PurgeAt: img.DeletedAt.Add(s.trashPeriod)}
+	}
+
+	// Render the trash page
+	tpl, err := template.ParseFiles("templates/trash.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, items)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
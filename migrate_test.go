@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"plain", "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n", []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"}},
+		{"no final semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"comments", "-- header; with a semicolon\nSELECT 1; -- trailing\n/* block; comment */ SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"semicolon in string", "INSERT INTO t VALUES ('a;b');", []string{"INSERT INTO t VALUES ('a;b')"}},
+		{"doubled quote", "INSERT INTO t VALUES ('it''s; fine');SELECT 2;", []string{"INSERT INTO t VALUES ('it''s; fine')", "SELECT 2"}},
+		{"quoted identifier", "SELECT \"a;b\", `c;d` FROM t;", []string{"SELECT \"a;b\", `c;d` FROM t"}},
+		{"dashes in string", "SELECT '--not a comment';", []string{"SELECT '--not a comment'"}},
+		{"trigger", `CREATE TRIGGER t AFTER INSERT ON a BEGIN
+	UPDATE b SET n = CASE WHEN n > 0 THEN n + 1 ELSE 1 END;
+	DELETE FROM c;
+END;
+SELECT 1;`, []string{`CREATE TRIGGER t AFTER INSERT ON a BEGIN
+	UPDATE b SET n = CASE WHEN n > 0 THEN n + 1 ELSE 1 END;
+	DELETE FROM c;
+END`, "SELECT 1"}},
+		{"empty statements", ";;\n;", nil},
+	}
+	for _, tt := range tests {
+		if got := splitSQLStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigratorUpAndDown(t *testing.T) {
+	m, err := newMigrator(openTestSQLite(t), sqliteDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(m.migrations) {
+		t.Fatalf("applied %d of %d migrations", len(done), len(m.migrations))
+	}
+	for range m.migrations {
+		if _, err := m.Down(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mig, err := m.Down(); mig != nil || err != nil {
+		t.Fatalf("Down with nothing applied = %v, %v", mig, err)
+	}
+}
+
+func TestMigratorRollsBackFailedMigration(t *testing.T) {
+	db := openTestSQLite(t)
+	m := &Migrator{db: db, migrations: []migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a (id INTEGER);"},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE b (id INTEGER);\nINSERT INTO a VALUES (1);\nINSERT INTO missing VALUES (1);"},
+	}}
+
+	done, err := m.Up()
+	if err == nil || len(done) != 1 {
+		t.Fatalf("Up = %d applied, %v; want the second migration to fail", len(done), err)
+	}
+
+	// Nothing of the failed migration is left, and it is still pending
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("table b left behind (%d, %v)", n, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM a").Scan(&n); err != nil || n != 0 {
+		t.Errorf("rows left in a (%d, %v)", n, err)
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status[0].AppliedAt == nil || status[1].AppliedAt != nil {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestMediaKeysMigrationRoundTrip(t *testing.T) {
+	db := openTestSQLite(t)
+	m, err := newMigrator(db, sqliteDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := m.migrations
+	m.migrations = all[:12]
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO gallery (path) VALUES ('uploads/old.png')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Up turns the path into a key; a key that starts like a path is added
+	// after it
+	m.migrations = all[:13]
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO gallery (path) VALUES ('uploads/new.png'), ('plain.png')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	rows, err := db.Query("SELECT path FROM gallery ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p)
+	}
+	if want := []string{"uploads/old.png", "uploads/new.png", "uploads/plain.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paths after down = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,28 @@
+-- Tables the application started out with. IF NOT EXISTS keeps existing
+-- databases untouched.
+CREATE TABLE IF NOT EXISTS users (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	username VARCHAR(255) NOT NULL UNIQUE,
+	email VARCHAR(255) NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	role VARCHAR(32) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	title VARCHAR(255) NOT NULL,
+	content TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS gallery (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	imageURL VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contact_entries (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	message TEXT NOT NULL
+);
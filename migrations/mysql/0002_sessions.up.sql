@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id CHAR(64) PRIMARY KEY,
+	user_id INT NOT NULL,
+	role VARCHAR(32) NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	last_seen DATETIME NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	user_agent VARCHAR(255) NOT NULL,
+	INDEX (expires_at)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS roles (
+	name VARCHAR(32) PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role VARCHAR(32) NOT NULL,
+	permission VARCHAR(64) NOT NULL,
+	PRIMARY KEY (role, permission)
+);
@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN author_id INT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Existing posts were public, so they start out published.
+ALTER TABLE posts ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'published';
+ALTER TABLE posts ADD COLUMN publish_at DATETIME NULL;
+ALTER TABLE posts ADD COLUMN published_at DATETIME NULL;
+CREATE INDEX posts_status_publish_at ON posts (status, publish_at);
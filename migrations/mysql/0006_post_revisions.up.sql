@@ -0,0 +1,10 @@
+CREATE TABLE post_revisions (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	post_id INT NOT NULL,
+	author_id INT NOT NULL,
+	created_at DATETIME NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	content TEXT NOT NULL,
+	note VARCHAR(255) NOT NULL DEFAULT '',
+	INDEX (post_id, id)
+);
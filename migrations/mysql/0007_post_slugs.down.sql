@@ -0,0 +1,3 @@
+DROP TABLE post_slug_history;
+DROP INDEX posts_slug ON posts;
+ALTER TABLE posts DROP COLUMN slug;
@@ -0,0 +1,11 @@
+-- Existing posts get a placeholder slug that editors can change later.
+ALTER TABLE posts ADD COLUMN slug VARCHAR(191) NOT NULL DEFAULT '';
+UPDATE posts SET slug = CONCAT('post-', id);
+CREATE UNIQUE INDEX posts_slug ON posts (slug);
+
+CREATE TABLE post_slug_history (
+	slug VARCHAR(191) PRIMARY KEY,
+	post_id INT NOT NULL,
+	changed_at DATETIME NOT NULL,
+	INDEX (post_id)
+);
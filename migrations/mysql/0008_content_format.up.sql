@@ -0,0 +1,3 @@
+-- Existing content was written as plain text.
+ALTER TABLE posts ADD COLUMN format VARCHAR(16) NOT NULL DEFAULT 'plain';
+ALTER TABLE post_revisions ADD COLUMN format VARCHAR(16) NOT NULL DEFAULT 'plain';
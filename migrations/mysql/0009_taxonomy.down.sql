@@ -0,0 +1,5 @@
+DELETE FROM role_permissions WHERE permission = 'taxonomy.manage';
+DROP TABLE post_tags;
+DROP TABLE post_categories;
+DROP TABLE tags;
+DROP TABLE categories;
@@ -0,0 +1,32 @@
+CREATE TABLE categories (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	slug VARCHAR(191) NOT NULL UNIQUE,
+	parent_id INT NULL,
+	INDEX (parent_id)
+);
+
+CREATE TABLE tags (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	slug VARCHAR(191) NOT NULL UNIQUE
+);
+
+CREATE TABLE post_categories (
+	post_id INT NOT NULL,
+	category_id INT NOT NULL,
+	PRIMARY KEY (post_id, category_id),
+	INDEX (category_id)
+);
+
+CREATE TABLE post_tags (
+	post_id INT NOT NULL,
+	tag_id INT NOT NULL,
+	PRIMARY KEY (post_id, tag_id),
+	INDEX (tag_id)
+);
+
+-- Roles that already exist get the new permission; fresh installs are
+-- seeded by the application.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'taxonomy.manage' FROM roles WHERE name IN ('admin', 'editor');
@@ -0,0 +1,11 @@
+CREATE TABLE search_documents (
+	kind VARCHAR(16) NOT NULL,
+	ref VARCHAR(191) NOT NULL,
+	title VARCHAR(255) NOT NULL,
+	body MEDIUMTEXT NOT NULL,
+	url VARCHAR(255) NOT NULL,
+	access VARCHAR(64) NOT NULL DEFAULT '',
+	owner_id INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (kind, ref),
+	FULLTEXT INDEX search_documents_text (title, body)
+) ENGINE=InnoDB;
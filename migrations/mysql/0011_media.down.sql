@@ -0,0 +1,18 @@
+DROP TABLE album_images;
+DROP TABLE albums;
+
+ALTER TABLE gallery
+	DROP COLUMN filename,
+	DROP COLUMN mime_type,
+	DROP COLUMN size,
+	DROP COLUMN width,
+	DROP COLUMN height,
+	DROP COLUMN checksum,
+	DROP COLUMN uploader_id,
+	DROP COLUMN alt_text,
+	DROP COLUMN caption,
+	DROP COLUMN created_at;
+
+ALTER TABLE gallery RENAME COLUMN path TO imageURL;
+
+DELETE FROM search_documents WHERE kind = 'image';
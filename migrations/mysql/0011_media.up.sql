@@ -0,0 +1,34 @@
+-- The stored path keeps the existing uploads/... values.
+ALTER TABLE gallery RENAME COLUMN imageURL TO path;
+
+ALTER TABLE gallery
+	ADD COLUMN filename VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN mime_type VARCHAR(100) NOT NULL DEFAULT '',
+	ADD COLUMN size BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN width INT NOT NULL DEFAULT 0,
+	ADD COLUMN height INT NOT NULL DEFAULT 0,
+	ADD COLUMN checksum CHAR(64) NOT NULL DEFAULT '',
+	ADD COLUMN uploader_id INT NOT NULL DEFAULT 0,
+	ADD COLUMN alt_text VARCHAR(255) NOT NULL DEFAULT '',
+	ADD COLUMN caption VARCHAR(1000) NOT NULL DEFAULT '',
+	ADD COLUMN created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE gallery SET filename = REPLACE(path, 'uploads/', '');
+
+CREATE TABLE albums (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	slug VARCHAR(191) NOT NULL UNIQUE,
+	position INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE album_images (
+	album_id INT NOT NULL,
+	image_id INT NOT NULL,
+	position INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (album_id, image_id),
+	INDEX (image_id)
+);
+
+-- Images are now indexed by ID; the startup reindex adds them back.
+DELETE FROM search_documents WHERE kind = 'image';
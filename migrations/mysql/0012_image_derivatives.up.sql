@@ -0,0 +1,10 @@
+CREATE TABLE image_derivatives (
+	image_id INT NOT NULL,
+	name VARCHAR(50) NOT NULL,
+	path VARCHAR(255) NOT NULL,
+	mime_type VARCHAR(100) NOT NULL,
+	width INT NOT NULL,
+	height INT NOT NULL,
+	size BIGINT NOT NULL,
+	PRIMARY KEY (image_id, name, mime_type)
+);
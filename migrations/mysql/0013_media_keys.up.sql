@@ -0,0 +1,3 @@
+-- Paths become keys in the media store, relative to the uploads directory.
+UPDATE gallery SET path = SUBSTRING(path, 9) WHERE path LIKE 'uploads/%';
+UPDATE image_derivatives SET path = SUBSTRING(path, 9) WHERE path LIKE 'uploads/%';
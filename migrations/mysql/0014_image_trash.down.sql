@@ -0,0 +1,3 @@
+ALTER TABLE gallery
+	DROP COLUMN file_missing,
+	DROP COLUMN deleted_at;
@@ -0,0 +1,3 @@
+ALTER TABLE gallery
+	ADD COLUMN deleted_at DATETIME NULL,
+	ADD COLUMN file_missing BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,7 @@
+DROP TABLE contact_notes;
+
+ALTER TABLE contact_entries
+	DROP INDEX contact_entries_status,
+	DROP COLUMN assignee_id,
+	DROP COLUMN status,
+	DROP COLUMN received_at;
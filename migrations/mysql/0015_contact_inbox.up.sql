@@ -0,0 +1,15 @@
+-- Entries sent before this migration are dated when it runs.
+ALTER TABLE contact_entries
+	ADD COLUMN received_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'new',
+	ADD COLUMN assignee_id INT NOT NULL DEFAULT 0,
+	ADD INDEX contact_entries_status (status);
+
+CREATE TABLE contact_notes (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	entry_id INT NOT NULL,
+	author_id INT NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	INDEX (entry_id)
+);
@@ -0,0 +1,17 @@
+CREATE TABLE mail_outbox (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	from_addr VARCHAR(255) NOT NULL,
+	-- One address per line
+	to_addrs TEXT NOT NULL,
+	reply_to VARCHAR(255) NOT NULL DEFAULT '',
+	subject VARCHAR(998) NOT NULL,
+	body_text MEDIUMTEXT NOT NULL,
+	body_html MEDIUMTEXT NOT NULL,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL,
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	sent_at DATETIME NULL,
+	INDEX mail_outbox_due (status, next_attempt_at)
+);
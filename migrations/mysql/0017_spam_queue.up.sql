@@ -0,0 +1,16 @@
+CREATE TABLE spam_reports (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	form VARCHAR(20) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	-- The submitted values as a JSON object
+	fields MEDIUMTEXT NOT NULL,
+	score INT NOT NULL,
+	-- One reason per line
+	reasons TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	INDEX (form)
+);
+
+-- Installs seeded before the permission existed get it for admins
+INSERT IGNORE INTO role_permissions (role, permission)
+	SELECT name, 'spam.manage' FROM roles WHERE name = 'admin';
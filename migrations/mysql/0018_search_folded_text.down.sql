@@ -0,0 +1,3 @@
+ALTER TABLE search_documents DROP INDEX search_documents_text;
+ALTER TABLE search_documents DROP COLUMN folded_title, DROP COLUMN folded_body;
+ALTER TABLE search_documents ADD FULLTEXT INDEX search_documents_text (title, body);
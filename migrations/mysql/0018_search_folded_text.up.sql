@@ -0,0 +1,8 @@
+-- Index the words as searchTokens folds them, so accented, Cyrillic and
+-- Greek text is found the way the in-process index finds it. The startup
+-- reindex fills the new columns.
+ALTER TABLE search_documents
+	ADD COLUMN folded_title TEXT NOT NULL,
+	ADD COLUMN folded_body MEDIUMTEXT NOT NULL;
+ALTER TABLE search_documents DROP INDEX search_documents_text;
+ALTER TABLE search_documents ADD FULLTEXT INDEX search_documents_text (folded_title, folded_body);
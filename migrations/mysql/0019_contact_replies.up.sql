@@ -0,0 +1,9 @@
+CREATE TABLE contact_replies (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	entry_id INT NOT NULL,
+	author_id INT NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	INDEX (entry_id)
+);
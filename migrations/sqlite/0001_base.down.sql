@@ -0,0 +1,4 @@
+DROP TABLE contact_entries;
+DROP TABLE gallery;
+DROP TABLE posts;
+DROP TABLE users;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	username TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL,
+	password TEXT NOT NULL,
+	role TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS gallery (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	imageURL TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contact_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	message TEXT NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS roles (
+	name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	PRIMARY KEY (role, permission)
+);
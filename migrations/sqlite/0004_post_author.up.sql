@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN author_id INTEGER NOT NULL DEFAULT 0;
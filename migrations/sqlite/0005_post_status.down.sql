@@ -0,0 +1,4 @@
+DROP INDEX posts_status_publish_at;
+ALTER TABLE posts DROP COLUMN published_at;
+ALTER TABLE posts DROP COLUMN publish_at;
+ALTER TABLE posts DROP COLUMN status;
@@ -0,0 +1,11 @@
+CREATE TABLE post_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	note TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX post_revisions_post_id ON post_revisions (post_id, id);
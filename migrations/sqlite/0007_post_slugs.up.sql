@@ -0,0 +1,12 @@
+-- Existing posts get a placeholder slug that editors can change later.
+ALTER TABLE posts ADD COLUMN slug TEXT NOT NULL DEFAULT '';
+UPDATE posts SET slug = 'post-' || id;
+CREATE UNIQUE INDEX posts_slug ON posts (slug);
+
+CREATE TABLE post_slug_history (
+	slug TEXT PRIMARY KEY,
+	post_id INTEGER NOT NULL,
+	changed_at DATETIME NOT NULL
+);
+
+CREATE INDEX post_slug_history_post_id ON post_slug_history (post_id);
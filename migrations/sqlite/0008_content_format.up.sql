@@ -0,0 +1,3 @@
+-- Existing content was written as plain text.
+ALTER TABLE posts ADD COLUMN format TEXT NOT NULL DEFAULT 'plain';
+ALTER TABLE post_revisions ADD COLUMN format TEXT NOT NULL DEFAULT 'plain';
@@ -0,0 +1,35 @@
+CREATE TABLE categories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT NOT NULL UNIQUE,
+	parent_id INTEGER NULL
+);
+
+CREATE INDEX categories_parent_id ON categories (parent_id);
+
+CREATE TABLE tags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE post_categories (
+	post_id INTEGER NOT NULL,
+	category_id INTEGER NOT NULL,
+	PRIMARY KEY (post_id, category_id)
+);
+
+CREATE INDEX post_categories_category_id ON post_categories (category_id);
+
+CREATE TABLE post_tags (
+	post_id INTEGER NOT NULL,
+	tag_id INTEGER NOT NULL,
+	PRIMARY KEY (post_id, tag_id)
+);
+
+CREATE INDEX post_tags_tag_id ON post_tags (tag_id);
+
+-- Roles that already exist get the new permission; fresh installs are
+-- seeded by the application.
+INSERT INTO role_permissions (role, permission)
+SELECT name, 'taxonomy.manage' FROM roles WHERE name IN ('admin', 'editor');
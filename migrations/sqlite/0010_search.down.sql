@@ -0,0 +1,2 @@
+-- SQLite databases are searched with the in-process index, which is rebuilt
+-- at startup and needs no tables.
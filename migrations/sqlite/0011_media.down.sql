@@ -0,0 +1,15 @@
+DROP TABLE album_images;
+DROP TABLE albums;
+
+ALTER TABLE gallery DROP COLUMN filename;
+ALTER TABLE gallery DROP COLUMN mime_type;
+ALTER TABLE gallery DROP COLUMN size;
+ALTER TABLE gallery DROP COLUMN width;
+ALTER TABLE gallery DROP COLUMN height;
+ALTER TABLE gallery DROP COLUMN checksum;
+ALTER TABLE gallery DROP COLUMN uploader_id;
+ALTER TABLE gallery DROP COLUMN alt_text;
+ALTER TABLE gallery DROP COLUMN caption;
+ALTER TABLE gallery DROP COLUMN created_at;
+
+ALTER TABLE gallery RENAME COLUMN path TO imageURL;
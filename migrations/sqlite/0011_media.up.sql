@@ -0,0 +1,32 @@
+-- The stored path keeps the existing uploads/... values.
+ALTER TABLE gallery RENAME COLUMN imageURL TO path;
+
+ALTER TABLE gallery ADD COLUMN filename TEXT NOT NULL DEFAULT '';
+ALTER TABLE gallery ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE gallery ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE gallery ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE gallery ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE gallery ADD COLUMN checksum TEXT NOT NULL DEFAULT '';
+ALTER TABLE gallery ADD COLUMN uploader_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE gallery ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE gallery ADD COLUMN caption TEXT NOT NULL DEFAULT '';
+-- SQLite only adds columns with constant defaults
+ALTER TABLE gallery ADD COLUMN created_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+UPDATE gallery SET filename = REPLACE(path, 'uploads/', ''), created_at = CURRENT_TIMESTAMP;
+
+CREATE TABLE albums (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	slug TEXT NOT NULL UNIQUE,
+	position INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE album_images (
+	album_id INTEGER NOT NULL,
+	image_id INTEGER NOT NULL,
+	position INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (album_id, image_id)
+);
+
+CREATE INDEX album_images_image_id ON album_images (image_id);
@@ -0,0 +1,10 @@
+CREATE TABLE image_derivatives (
+	image_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	path TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	PRIMARY KEY (image_id, name, mime_type)
+);
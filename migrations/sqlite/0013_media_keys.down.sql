@@ -0,0 +1,3 @@
+-- Keys become paths again; those already carrying the prefix are kept.
+UPDATE gallery SET path = 'uploads/' || path WHERE path NOT LIKE 'uploads/%';
+UPDATE image_derivatives SET path = 'uploads/' || path WHERE path NOT LIKE 'uploads/%';
@@ -0,0 +1,2 @@
+ALTER TABLE gallery DROP COLUMN file_missing;
+ALTER TABLE gallery DROP COLUMN deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE gallery ADD COLUMN deleted_at DATETIME NULL;
+ALTER TABLE gallery ADD COLUMN file_missing INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+DROP TABLE contact_notes;
+
+DROP INDEX contact_entries_status;
+ALTER TABLE contact_entries DROP COLUMN assignee_id;
+ALTER TABLE contact_entries DROP COLUMN status;
+ALTER TABLE contact_entries DROP COLUMN received_at;
@@ -0,0 +1,19 @@
+-- SQLite only adds columns with constant defaults
+ALTER TABLE contact_entries ADD COLUMN received_at DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';
+ALTER TABLE contact_entries ADD COLUMN status TEXT NOT NULL DEFAULT 'new';
+ALTER TABLE contact_entries ADD COLUMN assignee_id INTEGER NOT NULL DEFAULT 0;
+
+-- Entries sent before this migration are dated when it runs.
+UPDATE contact_entries SET received_at = CURRENT_TIMESTAMP;
+
+CREATE INDEX contact_entries_status ON contact_entries (status);
+
+CREATE TABLE contact_notes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	entry_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX contact_notes_entry_id ON contact_notes (entry_id);
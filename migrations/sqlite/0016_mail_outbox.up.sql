@@ -0,0 +1,18 @@
+CREATE TABLE mail_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	from_addr TEXT NOT NULL,
+	-- One address per line
+	to_addrs TEXT NOT NULL,
+	reply_to TEXT NOT NULL DEFAULT '',
+	subject TEXT NOT NULL,
+	body_text TEXT NOT NULL,
+	body_html TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	sent_at DATETIME NULL
+);
+
+CREATE INDEX mail_outbox_due ON mail_outbox (status, next_attempt_at);
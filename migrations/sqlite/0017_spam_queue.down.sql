@@ -0,0 +1,3 @@
+DELETE FROM role_permissions WHERE permission = 'spam.manage';
+
+DROP TABLE spam_reports;
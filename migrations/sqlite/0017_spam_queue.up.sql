@@ -0,0 +1,17 @@
+CREATE TABLE spam_reports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	form TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	-- The submitted values as a JSON object
+	fields TEXT NOT NULL,
+	score INTEGER NOT NULL,
+	-- One reason per line
+	reasons TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX spam_reports_form ON spam_reports (form);
+
+-- Installs seeded before the permission existed get it for admins
+INSERT OR IGNORE INTO role_permissions (role, permission)
+	SELECT name, 'spam.manage' FROM roles WHERE name = 'admin';
@@ -0,0 +1,2 @@
+-- SQLite databases are searched with the in-process index, which folds the
+-- text itself.
@@ -0,0 +1,10 @@
+CREATE TABLE contact_replies (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	entry_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX contact_replies_entry_id ON contact_replies (entry_id);
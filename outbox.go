@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Outbox message statuses
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email waiting in the outbox, or the record of one
+// that was sent or given up on.
+type OutboxMessage struct {
+	ID int
+	Message
+	Status    string
+	Attempts  int
+	LastError string
+	// NextAttemptAt is when a pending message is sent next.
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+const (
+	// defaultMailAttempts is how many times a message is tried when
+	// MAIL_MAX_ATTEMPTS is not set.
+	defaultMailAttempts = 8
+	// outboxBatch is how many messages one outbox run sends at most.
+	outboxBatch = 50
+	// outboxLease is how long a message being sent is kept from other
+	// senders.
+	outboxLease = 5 * time.Minute
+	// maxRetryDelay caps the wait between two attempts.
+	maxRetryDelay = 6 * time.Hour
+)
+
+// mailMaxAttempts returns how many times a message is tried before it is
+// given up on.
+func mailMaxAttempts() int {
+	v := getEnv("MAIL_MAX_ATTEMPTS", "")
+	if v == "" {
+		return defaultMailAttempts
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Printf("invalid MAIL_MAX_ATTEMPTS %q, using %d", v, defaultMailAttempts)
+		n = defaultMailAttempts
+	}
+	return n
+}
+
+// retryDelay returns the wait after the given number of failed attempts:
+// a minute after the first, doubling up to maxRetryDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// queueMail puts the message in the outbox, from MAIL_FROM unless it names
+// a sender. It is sent in the background, and retried until it goes
+// through.
+func (s *Server) queueMail(msg *Message) error {
+	if msg.From == "" {
+		msg.From = s.mailFrom
+	}
+	if err := msg.check(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err := s.outbox.Enqueue(&OutboxMessage{Message: *msg, Status: OutboxPending, NextAttemptAt: now, CreatedAt: now})
+	if err != nil {
+		return err
+	}
+
+	// Wake the sender rather than wait for its next run
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// deliverOutbox sends the due messages in the outbox. A failed message is
+// tried again later, and given up on after mailMaxAttempts attempts.
+func (s *Server) deliverOutbox(now time.Time) error {
+	due, err := s.outbox.Due(now, outboxBatch)
+	if err != nil {
+		return err
+	}
+	for _, msg := range due {
+		// Another instance may be sending the same message
+		claimed, err := s.outbox.Claim(msg.ID, now, now.Add(outboxLease))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		attempts := msg.Attempts + 1
+		sendErr := s.mailer.Send(&msg.Message)
+		switch {
+		case sendErr == nil:
+			err = s.outbox.MarkSent(msg.ID, time.Now().UTC())
+		case attempts >= s.mailMaxAttempts:
+			log.Printf("mail %d to %v failed for good after %d attempts: %v", msg.ID, msg.To, attempts, sendErr)
+			err = s.outbox.Fail(msg.ID, attempts, sendErr.Error())
+		default:
+			log.Printf("mail %d to %v failed, retrying: %v", msg.ID, msg.To, sendErr)
+			err = s.outbox.Retry(msg.ID, attempts, sendErr.Error(), now.Add(retryDelay(attempts)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runOutbox sends the outbox every interval, and as soon as a message is
+// queued.
+func (s *Server) runOutbox(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		if err := s.deliverOutbox(time.Now().UTC()); err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-tick.C:
+		case <-s.outboxWake:
+		}
+	}
+}
+
+// runMailCommand runs the mail subcommand:
+//
+//	mail test <address>
+//
+// It sends the test message straight through the configured transport,
+// bypassing the outbox, so a misconfiguration shows at once.
+func runMailCommand(args []string) error {
+	const usage = "usage: mail test <address>"
+	if len(args) != 2 || args[0] != "test" {
+		return errors.New(usage)
+	}
+	mailer, err := newMailer()
+	if err != nil {
+		return err
+	}
+	msg, err := renderMail("test", struct{ Transport string }{getEnv("MAIL_TRANSPORT", "log")})
+	if err != nil {
+		return err
+	}
+	msg.From = getEnv("MAIL_FROM", defaultMailFrom)
+	msg.To = []string{args[1]}
+	if err := mailer.Send(msg); err != nil {
+		return err
+	}
+	fmt.Println("sent a test message to", args[1])
+	return nil
+}
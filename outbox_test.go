@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, maxRetryDelay},
+		{100, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// flakyMailer fails the first failures sends, then delivers to a
+// memoryMailer.
+type flakyMailer struct {
+	failures int
+	memoryMailer
+}
+
+func (m *flakyMailer) Send(msg *Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("relay unavailable")
+	}
+	return m.memoryMailer.Send(msg)
+}
+
+func TestDeliverOutbox(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		runs         int
+		wantStatus   string
+		wantAttempts int
+		wantSent     int
+	}{
+		{"first try", 0, 3, 1, OutboxSent, 1, 1},
+		{"after retries", 2, 3, 3, OutboxSent, 3, 1},
+		{"gives up", 5, 3, 3, OutboxFailed, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			mailer := &flakyMailer{failures: tt.failures}
+			outbox := &memoryOutboxRepository{}
+			s.mailer, s.outbox, s.mailMaxAttempts = mailer, outbox, tt.maxAttempts
+
+			if err := s.queueMail(&Message{To: []string{"a@example.com"}, Subject: "Hi", Text: "Hello"}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Each run happens once the previous retry is due
+			now := time.Now().UTC()
+			for i := 0; i < tt.runs; i++ {
+				if err := s.deliverOutbox(now); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(maxRetryDelay)
+			}
+
+			msg := outbox.message(1)
+			if msg.Status != tt.wantStatus || msg.Attempts != tt.wantAttempts {
+				t.Errorf("status, attempts = %s, %d, want %s, %d", msg.Status, msg.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if got := len(mailer.Messages()); got != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", got, tt.wantSent)
+			}
+			if tt.wantStatus == OutboxSent && mailer.Messages()[0].From != s.mailFrom {
+				t.Errorf("from = %q, want %q", mailer.Messages()[0].From, s.mailFrom)
+			}
+		})
+	}
+}
+
+func TestDeliverOutboxWaitsForRetry(t *testing.T) {
+	s := newTestServer(t)
+	mailer := &flakyMailer{failures: 1}
+	outbox := &memoryOutboxRepository{}
+	s.mailer, s.outbox = mailer, outbox
+
+	if err := s.queueMail(&Message{To: []string{"a@example.com"}, Subject: "Hi", Text: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, at := range []time.Time{now, now.Add(30 * time.Second)} {
+		if err := s.deliverOutbox(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := outbox.message(1); msg.Attempts != 1 || msg.Status != OutboxPending {
+		t.Fatalf("status, attempts = %s, %d: retried before the delay", msg.Status, msg.Attempts)
+	}
+	if err := s.deliverOutbox(now.Add(retryDelay(1))); err != nil {
+		t.Fatal(err)
+	}
+	if msg := outbox.message(1); msg.Status != OutboxSent {
+		t.Errorf("status = %s after the delay", msg.Status)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page size limits shared by every HTML and JSON listing.
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// listing describes the pagination a listing supports.
+type listing struct {
+	// SortKeys are the accepted values of the sort parameter; the first
+	// one is the default. A leading "-" sorts in descending order.
+	SortKeys []string
+	// Keyset allows cursor pagination with the after parameter.
+	Keyset bool
+}
+
+var (
+	postListing    = listing{SortKeys: postSortKeys, Keyset: true}
+	galleryListing = listing{SortKeys: []string{"id", "-id"}, Keyset: true}
+	contactListing = listing{SortKeys: []string{"-id", "id"}, Keyset: true}
+	spamListing    = listing{SortKeys: []string{"-id", "id"}, Keyset: true}
+	// Albums keep their own order, so they only page by number.
+	albumListing  = listing{}
+	searchListing = listing{}
+)
+
+// PageRequest selects one page of a listing, either by page number or by
+// the cursor of the last item already seen.
+type PageRequest struct {
+	Page    int
+	PerPage int
+	Sort    string
+	// After continues the listing past this cursor. Page is ignored when
+	// it is set.
+	After *Cursor
+}
+
+// PageQuery is what repositories need to load a page. Limit is one more
+// than the page size so the caller can tell whether another page follows.
+type PageQuery struct {
+	Sort   string
+	Limit  int
+	Offset int
+	After  *Cursor
+}
+
+// Query returns the repository query for the page.
+func (p PageRequest) Query() PageQuery {
+	q := PageQuery{Sort: p.Sort, Limit: p.PerPage + 1, After: p.After}
+	if p.After == nil {
+		q.Offset = (p.Page - 1) * p.PerPage
+	}
+	return q
+}
+
+// Cursor is the position of an item in a sorted listing: the value of the
+// sort key and the ID that breaks ties.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+// Encode returns the opaque form of the cursor used in URLs.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// pageParamError reports an invalid pagination parameter.
+type pageParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *pageParamError) Error() string {
+	return e.Param + " " + e.Message
+}
+
+// parsePageRequest reads the page, per_page, sort and after parameters.
+func parsePageRequest(q url.Values, l listing) (PageRequest, error) {
+	var req PageRequest
+	var err error
+
+	req.Page, err = positiveIntParam(q.Get("page"), 1)
+	if err != nil {
+		return req, &pageParamError{"page", "must be a positive integer"}
+	}
+	req.PerPage, err = positiveIntParam(q.Get("per_page"), defaultPerPage)
+	if err != nil || req.PerPage > maxPerPage {
+		return req, &pageParamError{"per_page", fmt.Sprintf("must be between 1 and %d", maxPerPage)}
+	}
+
+	// Check the sort key against the listing's keys
+	req.Sort = q.Get("sort")
+	if req.Sort != "" && !l.sorts(req.Sort) {
+		return req, &pageParamError{"sort", "must be one of " + strings.Join(l.SortKeys, ", ")}
+	}
+
+	// Continue from a cursor, keeping the sort it was made for
+	if v := q.Get("after"); v != "" {
+		if !l.Keyset {
+			return req, &pageParamError{"after", "is not supported here"}
+		}
+		req.After, err = decodeCursor(v)
+		if err != nil || !l.sorts(req.After.Sort) {
+			return req, &pageParamError{"after", "is not a valid cursor"}
+		}
+		if req.Sort != "" && req.Sort != req.After.Sort {
+			return req, &pageParamError{"after", "was made for a different sort"}
+		}
+		req.Sort = req.After.Sort
+	}
+	if req.Sort == "" && len(l.SortKeys) > 0 {
+		req.Sort = l.SortKeys[0]
+	}
+	return req, nil
+}
+
+func (l listing) sorts(key string) bool {
+	for _, k := range l.SortKeys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Pagination describes a loaded page. Its methods render the page
+// navigation in templates and its meta method fills JSON responses.
+type Pagination struct {
+	PageRequest
+	Total int
+	// NextCursor continues the listing after this page; it is empty on
+	// the last page.
+	NextCursor string
+
+	more  bool
+	path  string
+	query url.Values
+}
+
+// newPagination starts the pagination of a page requested by r. Links keep
+// the request's other query parameters.
+func newPagination(r *http.Request, req PageRequest, total int) *Pagination {
+	q := r.URL.Query()
+	q.Del("page")
+	q.Del("after")
+	return &Pagination{PageRequest: req, Total: total, path: r.URL.Path, query: q}
+}
+
+// fit records whether more items were loaded than fit on the page and
+// returns how many to keep.
+func (p *Pagination) fit(n int) int {
+	if n > p.PerPage {
+		p.more = true
+		return p.PerPage
+	}
+	return n
+}
+
+// setNext sets the cursor of the page's last item, if another page follows.
+func (p *Pagination) setNext(last Cursor) {
+	if p.more {
+		last.Sort = p.Sort
+		p.NextCursor = last.Encode()
+	}
+}
+
+// Keyset reports whether the page was requested by cursor.
+func (p *Pagination) Keyset() bool {
+	return p.After != nil
+}
+
+func (p *Pagination) HasPrev() bool {
+	return p.Keyset() || p.Page > 1
+}
+
+func (p *Pagination) HasNext() bool {
+	return p.more
+}
+
+// PrevURL links to the previous page. Cursors only go forward, so from a
+// cursor page it links to the first page.
+func (p *Pagination) PrevURL() string {
+	if p.Keyset() {
+		return p.URL(1)
+	}
+	return p.URL(p.Page - 1)
+}
+
+// NextURL links to the next page, by cursor when the page was loaded by
+// cursor and by number otherwise.
+func (p *Pagination) NextURL() string {
+	if p.Keyset() {
+		q := p.values()
+		q.Set("after", p.NextCursor)
+		return p.path + "?" + q.Encode()
+	}
+	return p.URL(p.Page + 1)
+}
+
+// URL links to the numbered page n.
+func (p *Pagination) URL(n int) string {
+	q := p.values()
+	if n > 1 {
+		q.Set("page", strconv.Itoa(n))
+	}
+	if len(q) == 0 {
+		return p.path
+	}
+	return p.path + "?" + q.Encode()
+}
+
+func (p *Pagination) values() url.Values {
+	q := make(url.Values, len(p.query))
+	for k, v := range p.query {
+		q[k] = v
+	}
+	return q
+}
+
+// LastPage returns the number of the last page.
+func (p *Pagination) LastPage() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// pageLink is one entry of the numbered page navigation. Gap entries stand
+// for the pages left out between two links.
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+	Gap     bool
+}
+
+// pageWindow is the number of pages linked on each side of the current one.
+const pageWindow = 2
+
+// Pages returns the numbered navigation: the first and last page and the
+// pages around the current one. Cursor pages have no numbers.
+func (p *Pagination) Pages() []pageLink {
+	if p.Keyset() {
+		return nil
+	}
+	last := p.LastPage()
+	if last <= 1 {
+		return nil
+	}
+	from, to := p.Page-pageWindow, p.Page+pageWindow
+	if from < 2 {
+		from = 2
+	}
+	if to > last-1 {
+		to = last - 1
+	}
+
+	links := []pageLink{p.pageLink(1)}
+	if from > 2 {
+		links = append(links, pageLink{Gap: true})
+	}
+	for n := from; n <= to; n++ {
+		links = append(links, p.pageLink(n))
+	}
+	if to < last-1 {
+		links = append(links, pageLink{Gap: true})
+	}
+	return append(links, p.pageLink(last))
+}
+
+func (p *Pagination) pageLink(n int) pageLink {
+	return pageLink{Number: n, URL: p.URL(n), Current: n == p.Page}
+}
+
+// meta returns the pagination fields of a JSON list response.
+func (p *Pagination) meta() apiListMeta {
+	m := apiListMeta{PerPage: p.PerPage, Total: p.Total, Sort: p.Sort, NextCursor: p.NextCursor}
+	if !p.Keyset() {
+		m.Page = p.Page
+	}
+	return m
+}
+
+// postPage loads the requested page of the posts matching filter.
+func (s *Server) postPage(r *http.Request, filter PostFilter, req PageRequest) ([]*Post, *Pagination, error) {
+	posts, total, err := s.posts.ListPage(filter, req.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	posts = posts[:pages.fit(len(posts))]
+	if len(posts) > 0 {
+		pages.setNext(posts[len(posts)-1].cursor(req.Sort))
+	}
+	return posts, pages, nil
+}
+
+// galleryPage loads the requested page of gallery images with their
+// derivatives.
+func (s *Server) galleryPage(r *http.Request, req PageRequest) ([]Image, *Pagination, error) {
+	images, total, err := s.gallery.ListPage(req.Query())
+	if err == nil {
+		err = s.loadDerivatives(images)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	images = images[:pages.fit(len(images))]
+	if len(images) > 0 {
+		pages.setNext(Cursor{ID: images[len(images)-1].ID})
+	}
+	return images, pages, nil
+}
+
+// contactPage loads the requested page of the contact entries matching
+// the filter.
+func (s *Server) contactPage(r *http.Request, filter ContactFilter, req PageRequest) ([]ContactEntry, *Pagination, error) {
+	entries, total, err := s.contacts.ListPage(filter, req.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	entries = entries[:pages.fit(len(entries))]
+	if len(entries) > 0 {
+		pages.setNext(Cursor{ID: entries[len(entries)-1].ID})
+	}
+	return entries, pages, nil
+}
+
+// spamPage loads the requested page of the spam queue, for one form or
+// all of them.
+func (s *Server) spamPage(r *http.Request, form string, req PageRequest) ([]SpamReport, *Pagination, error) {
+	reports, total, err := s.spamReports.ListPage(form, req.Query())
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	reports = reports[:pages.fit(len(reports))]
+	if len(reports) > 0 {
+		pages.setNext(Cursor{ID: reports[len(reports)-1].ID})
+	}
+	return reports, pages, nil
+}
+
+// albumPage loads the requested page of an album's images with their
+// derivatives.
+func (s *Server) albumPage(r *http.Request, albumID int, req PageRequest) ([]Image, *Pagination, error) {
+	images, total, err := s.gallery.AlbumPage(albumID, req.Query())
+	if err == nil {
+		err = s.loadDerivatives(images)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	return images[:pages.fit(len(images))], pages, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursors := []Cursor{
+		{Sort: "id", ID: 1},
+		{Sort: "-title", Value: "Hello, world", ID: 42},
+		{Sort: "title", Value: "ünïcödé / & ?", ID: 7},
+	}
+	for _, c := range cursors {
+		encoded := c.Encode()
+		if url.QueryEscape(encoded) != encoded {
+			t.Errorf("cursor %q needs escaping in URLs", encoded)
+		}
+		got, err := decodeCursor(encoded)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", encoded, err)
+		}
+		if *got != c {
+			t.Errorf("round trip = %+v, want %+v", *got, c)
+		}
+	}
+}
+
+func TestParsePageRequest(t *testing.T) {
+	after := Cursor{Sort: "-id", ID: 10}
+	tests := []struct {
+		name    string
+		query   string
+		l       listing
+		want    PageRequest
+		wantErr string
+	}{
+		{"defaults", "", postListing, PageRequest{Page: 1, PerPage: defaultPerPage, Sort: "id"}, ""},
+		{"page and size", "page=3&per_page=50&sort=-title", postListing, PageRequest{Page: 3, PerPage: 50, Sort: "-title"}, ""},
+		{"cursor keeps its sort", "after=" + after.Encode(), postListing, PageRequest{Page: 1, PerPage: defaultPerPage, Sort: "-id", After: &after}, ""},
+		{"bad page", "page=0", postListing, PageRequest{}, "page must be a positive integer"},
+		{"too large", "per_page=101", postListing, PageRequest{}, "per_page must be between 1 and 100"},
+		{"unknown sort", "sort=author", postListing, PageRequest{}, "sort must be one of id, -id, title, -title"},
+		{"garbage cursor", "after=%%%", postListing, PageRequest{}, "after is not a valid cursor"},
+		{"cursor for another sort", "sort=id&after=" + after.Encode(), postListing, PageRequest{}, "after was made for a different sort"},
+		{"no keyset", "after=" + after.Encode(), albumListing, PageRequest{}, "after is not supported here"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				q = url.Values{"after": {"%%%"}}
+			}
+			got, err := parsePageRequest(q, tt.l)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	q := PageRequest{Page: 3, PerPage: 20, Sort: "id"}.Query()
+	if q.Offset != 40 || q.Limit != 21 {
+		t.Errorf("offset, limit = %d, %d, want 40, 21", q.Offset, q.Limit)
+	}
+	q = PageRequest{Page: 3, PerPage: 20, Sort: "id", After: &Cursor{Sort: "id", ID: 5}}.Query()
+	if q.Offset != 0 {
+		t.Errorf("keyset offset = %d, want 0", q.Offset)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost used for new hashes. Stored hashes with a
+// lower cost are upgraded on the next successful login.
+const passwordCost = 12
+
+// dummyPasswordHash is checked when a login names an unknown user, so the
+// answer takes as long as for a known user with a wrong password.
+var dummyPasswordHash, _ = hashPassword("not a real password")
+
+const (
+	// minPasswordLength is the fewest characters a new password may have.
+	minPasswordLength = 8
+	// maxPasswordBytes is as long as bcrypt hashes; it ignores the rest.
+	maxPasswordBytes = 72
+)
+
+// hashPassword returns a bcrypt hash string. The "$2a$<cost>$" prefix records
+// the algorithm version and cost alongside the hash.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func isPasswordHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") ||
+		strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// checkPassword reports whether password matches the stored value and
+// whether the stored value should be replaced with a fresh hash. Rows that
+// predate hashing hold the plaintext password and are compared as such.
+func checkPassword(stored, password string) (ok bool, needsRehash bool) {
+	if !isPasswordHash(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost < passwordCost
+}
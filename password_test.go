@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDummyPasswordHash(t *testing.T) {
+	// Checking the dummy hash must cost as much as checking a real one
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil || cost != passwordCost {
+		t.Fatalf("dummy hash cost = %d, %v, want %d", cost, err, passwordCost)
+	}
+	if ok, _ := checkPassword(dummyPasswordHash, ""); ok {
+		t.Error("the dummy hash accepted a password")
+	}
+}
+
+func TestLoginUnknownUser(t *testing.T) {
+	s := newTestServer(t)
+	addUser(t, s, "known", RoleUser)
+	for _, form := range []string{"username=unknown&password=secret-password", "username=known&password=wrong-password"} {
+		if rec := do(s, nil, http.MethodPost, "/login", form, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: code = %d, want 401", form, rec.Code)
+		}
+	}
+}
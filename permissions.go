@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Permissions
+const (
+	PermDashboardAccess = "dashboard.access"
+	PermPostsCreate     = "posts.create"
+	PermPostsEditOwn    = "posts.edit.own"
+	PermPostsEditAny    = "posts.edit.any"
+	PermPostsPublish    = "posts.publish"
+	PermPostsDeleteOwn  = "posts.delete.own"
+	PermPostsDeleteAny  = "posts.delete.any"
+	PermGalleryUpload   = "gallery.upload"
+	PermGalleryDelete   = "gallery.delete"
+	PermTaxonomyManage  = "taxonomy.manage"
+	PermContactsRead    = "contacts.read"
+	PermSpamManage      = "spam.manage"
+	PermUsersManage     = "users.manage"
+)
+
+// Base permissions checked against a resource; see can.
+const (
+	PermPostsEdit   = "posts.edit"
+	PermPostsDelete = "posts.delete"
+)
+
+const (
+	permissionOwnSuffix = ".own"
+	permissionAnySuffix = ".any"
+)
+
+var allPermissions = []string{
+	PermDashboardAccess,
+	PermPostsCreate,
+	PermPostsEditOwn,
+	PermPostsEditAny,
+	PermPostsPublish,
+	PermPostsDeleteOwn,
+	PermPostsDeleteAny,
+	PermGalleryUpload,
+	PermGalleryDelete,
+	PermTaxonomyManage,
+	PermContactsRead,
+	PermSpamManage,
+	PermUsersManage,
+}
+
+// defaultRolePermissions seeds the role tables the first time they are
+// created.
+var defaultRolePermissions = map[string][]string{
+	RoleAdmin: allPermissions,
+	RoleEditor: {
+		PermDashboardAccess,
+		PermPostsCreate, PermPostsEditAny, PermPostsPublish, PermPostsDeleteAny,
+		PermGalleryUpload, PermGalleryDelete,
+		PermTaxonomyManage,
+	},
+	RoleAuthor: {
+		PermDashboardAccess,
+		PermPostsCreate, PermPostsEditOwn, PermPostsDeleteOwn,
+		PermGalleryUpload,
+	},
+	RoleUser: {},
+}
+
+// Resource is implemented by records that belong to a user.
+type Resource interface {
+	OwnerID() int
+}
+
+// permissionCache caches the role → permission bundles kept in the role
+// repository.
+type permissionCache struct {
+	repo  RoleRepository
+	mu    sync.RWMutex
+	roles map[string]map[string]bool
+}
+
+func newPermissionCache(repo RoleRepository) *permissionCache {
+	return &permissionCache{repo: repo}
+}
+
+// seedDefaults stores the default bundles when no roles exist yet.
+func (c *permissionCache) seedDefaults() error {
+	roles, err := c.repo.All()
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		return nil
+	}
+	for role, perms := range defaultRolePermissions {
+		if err := c.repo.Save(role, perms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *permissionCache) load() error {
+	bundles, err := c.repo.All()
+	if err != nil {
+		return err
+	}
+
+	roles := make(map[string]map[string]bool, len(bundles))
+	for role, perms := range bundles {
+		roles[role] = make(map[string]bool, len(perms))
+		for _, perm := range perms {
+			roles[role][perm] = true
+		}
+	}
+
+	c.mu.Lock()
+	c.roles = roles
+	c.mu.Unlock()
+	return nil
+}
+
+// save replaces a role's bundle and refreshes the cache.
+func (c *permissionCache) save(role string, perms []string) error {
+	if err := c.repo.Save(role, perms); err != nil {
+		return err
+	}
+	return c.load()
+}
+
+func (c *permissionCache) has(role, perm string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.roles[role][perm]
+}
+
+func (c *permissionCache) exists(role string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.roles[role]
+	return ok
+}
+
+func (c *permissionCache) roleNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.roles))
+	for name := range c.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// can reports whether the user holds perm. When resource is given, perm is
+// treated as a base permission: holding perm+".any" grants access to every
+// resource, holding perm+".own" only to resources the user owns.
+func (c *permissionCache) can(user *User, perm string, resource Resource) bool {
+	if user == nil {
+		return false
+	}
+	if c.has(user.Role, perm) {
+		return true
+	}
+	if resource == nil {
+		return false
+	}
+	if c.has(user.Role, perm+permissionAnySuffix) {
+		return true
+	}
+	return c.has(user.Role, perm+permissionOwnSuffix) && resource.OwnerID() == user.ID
+}
+
+// mayHave reports whether the user holds perm in any scope. It is used to
+// gate routes before the resource is known.
+func (c *permissionCache) mayHave(user *User, perm string) bool {
+	if user == nil {
+		return false
+	}
+	return c.has(user.Role, perm) ||
+		c.has(user.Role, perm+permissionAnySuffix) ||
+		c.has(user.Role, perm+permissionOwnSuffix)
+}
+
+// currentUser returns the signed-in user attached to the request by the
+// auth middleware.
+func currentUser(r *http.Request) *User {
+	s := sessionFromContext(r.Context())
+	if s == nil {
+		return nil
+	}
+	return &User{ID: s.UserID, Role: s.Role}
+}
+
+// requirePermission lets signed-in users through when they hold perm in any
+// scope, and answers 403 otherwise.
+func (s *Server) requirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return s.requireRoles()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.perms.mayHave(currentUser(r), perm) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+func isKnownPermission(perm string) bool {
+	for _, p := range allPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func validRoleName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	return strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789_-") == ""
+}
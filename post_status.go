@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Post statuses
+const (
+	PostDraft     = "draft"
+	PostInReview  = "in_review"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
+var postStatuses = []string{PostDraft, PostInReview, PostScheduled, PostPublished, PostArchived}
+
+// publishAtLayout is the format of <input type="datetime-local"> values.
+const publishAtLayout = "2006-01-02T15:04"
+
+var (
+	errInvalidPostStatus = errors.New("invalid post status")
+	errPublishAtRequired = errors.New("scheduled posts need a publish time")
+)
+
+func validPostStatus(status string) bool {
+	for _, st := range postStatuses {
+		if status == st {
+			return true
+		}
+	}
+	return false
+}
+
+// statusNeedsPublish reports whether moving a post into status requires the
+// posts.publish permission.
+func statusNeedsPublish(status string) bool {
+	return status == PostScheduled || status == PostPublished || status == PostArchived
+}
+
+// setPostStatus moves the post to status and keeps its timestamps
+// consistent. A scheduled post whose publish time has already passed is
+// published right away.
+func setPostStatus(post *Post, status string, publishAt *time.Time, now time.Time) error {
+	if !validPostStatus(status) {
+		return errInvalidPostStatus
+	}
+	now = now.UTC()
+
+	if status == PostScheduled {
+		if publishAt == nil {
+			return errPublishAtRequired
+		}
+		at := publishAt.UTC()
+		if at.After(now) {
+			post.Status = PostScheduled
+			post.PublishAt = &at
+			return nil
+		}
+		status = PostPublished
+	}
+
+	post.Status = status
+	post.PublishAt = nil
+	if status == PostPublished && post.PublishedAt == nil {
+		post.PublishedAt = &now
+	}
+	return nil
+}
+
+// canSetPostStatus reports whether the user may move the post to status at
+// publishAt. Publishing, scheduling, archiving or taking a post out of one of
+// those states requires posts.publish.
+func (s *Server) canSetPostStatus(user *User, post *Post, status string, publishAt *time.Time) bool {
+	unchanged := post.Status == status && (status != PostScheduled || sameTime(post.PublishAt, publishAt))
+	if unchanged {
+		return true
+	}
+	if !statusNeedsPublish(status) && !statusNeedsPublish(post.Status) {
+		return true
+	}
+	return s.perms.can(user, PermPostsPublish, nil)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// parsePublishAt parses a datetime-local form value in the server's local
+// time zone. An empty value yields nil.
+func parsePublishAt(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(publishAtLayout, v, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// runPostScheduler publishes due scheduled posts every interval.
+func (s *Server) runPostScheduler(interval time.Duration) {
+	for range time.Tick(interval) {
+		n, err := s.posts.PublishDue(time.Now().UTC())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("published %d scheduled post(s)", n)
+			if err := s.reindexPosts(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by repositories when no record matches.
+var ErrNotFound = errors.New("not found")
+
+type PostRepository interface {
+	List(filter PostFilter) ([]*Post, error)
+	// ListPage returns a page of the matching posts and how many match in
+	// total.
+	ListPage(filter PostFilter, page PageQuery) ([]*Post, int, error)
+	Get(id int) (*Post, error)
+	GetBySlug(slug string) (*Post, error)
+	// SlugRedirect returns the ID of the post that used slug before it was
+	// renamed.
+	SlugRedirect(slug string) (int, error)
+	Create(post *Post) (int, error)
+	// Update saves the post. A changed slug is kept in the slug history so
+	// old links can be redirected.
+	Update(post *Post) error
+	Delete(id int) error
+	// PublishDue publishes scheduled posts whose publish time has passed
+	// and returns how many were published.
+	PublishDue(now time.Time) (int, error)
+}
+
+// RevisionRepository stores the immutable edit history of posts.
+type RevisionRepository interface {
+	List(postID int) ([]Revision, error)
+	Get(id int) (*Revision, error)
+	Create(rev *Revision) (int, error)
+}
+
+// TaxonomyRepository stores categories, tags and their assignment to posts.
+type TaxonomyRepository interface {
+	Categories() ([]Category, error)
+	GetCategoryBySlug(slug string) (*Category, error)
+	CreateCategory(c *Category) (int, error)
+	UpdateCategory(c *Category) error
+	// DeleteCategory removes the category and moves its children up to its
+	// parent.
+	DeleteCategory(id int) error
+	Tags() ([]Tag, error)
+	GetTagBySlug(slug string) (*Tag, error)
+	CreateTag(t *Tag) (int, error)
+	UpdateTag(t *Tag) error
+	DeleteTag(id int) error
+	PostCategories(postID int) ([]Category, error)
+	PostTags(postID int) ([]Tag, error)
+	SetPostCategories(postID int, categoryIDs []int) error
+	SetPostTags(postID int, tagIDs []int) error
+}
+
+type UserRepository interface {
+	List() ([]User, error)
+	GetByUsername(username string) (*User, error)
+	Create(user *User) (int, error)
+	UpdatePassword(id int, hash string) error
+	UpdateRole(id int, role string) error
+}
+
+// GalleryRepository stores the media library and its albums.
+type GalleryRepository interface {
+	// List and ListPage leave out images in the trash.
+	List() ([]Image, error)
+	ListPage(page PageQuery) ([]Image, int, error)
+	// Get returns the image, even when it is in the trash.
+	Get(id int) (*Image, error)
+	// GetByKey returns the image whose original or resized copy is stored
+	// under the media key, even when it is in the trash.
+	GetByKey(key string) (*Image, error)
+	Add(image *Image) (int, error)
+	// Update saves the alt text and caption of the image.
+	Update(image *Image) error
+	// Trash moves the image to the trash, hiding it from listings and
+	// albums until it is restored or deleted.
+	Trash(id int, at time.Time) error
+	// Restore takes the image out of the trash.
+	Restore(id int) error
+	// Trashed returns the images in the trash, longest there first.
+	Trashed() ([]Image, error)
+	// Delete removes the image from the library and its albums, along
+	// with its derivative records.
+	Delete(id int) error
+	// MarkMissing records whether the image's file is missing from the
+	// media store.
+	MarkMissing(id int, missing bool) error
+	// Derivatives returns the resized copies stored for the image.
+	Derivatives(imageID int) ([]Derivative, error)
+	// SetDerivatives replaces the image's derivative records.
+	SetDerivatives(imageID int, derivatives []Derivative) error
+
+	// Albums returns every album in display order.
+	Albums() ([]Album, error)
+	GetAlbum(id int) (*Album, error)
+	GetAlbumBySlug(slug string) (*Album, error)
+	CreateAlbum(a *Album) (int, error)
+	UpdateAlbum(a *Album) error
+	// DeleteAlbum removes the album; its images stay in the library.
+	DeleteAlbum(id int) error
+	// AlbumImages returns the album's images in album order, leaving out
+	// those in the trash.
+	AlbumImages(albumID int) ([]Image, error)
+	// AlbumPage returns a page of the album's images in album order.
+	AlbumPage(albumID int, page PageQuery) ([]Image, int, error)
+	// ImageAlbums returns the IDs of the albums holding the image.
+	ImageAlbums(imageID int) ([]int, error)
+	// SetImageAlbums puts the image in exactly the given albums, at the
+	// end of the ones it was not in yet.
+	SetImageAlbums(imageID int, albumIDs []int) error
+	// OrderAlbum arranges the album's images in the order given.
+	OrderAlbum(albumID int, imageIDs []int) error
+}
+
+// ContactRepository stores the messages sent with the contact form and
+// their triage.
+type ContactRepository interface {
+	List() ([]ContactEntry, error)
+	// ListPage returns a page of the entries matching the filter, and how
+	// many match in all.
+	ListPage(filter ContactFilter, page PageQuery) ([]ContactEntry, int, error)
+	Get(id int) (*ContactEntry, error)
+	Create(entry *ContactEntry) error
+	SetStatus(id int, status string) error
+	// Assign hands the entry to a user, or to nobody when userID is 0.
+	Assign(id, userID int) error
+	// StatusCounts returns how many entries are in each status.
+	StatusCounts() (map[string]int, error)
+	// Notes returns the entry's notes, oldest first.
+	Notes(entryID int) ([]ContactNote, error)
+	AddNote(note *ContactNote) (int, error)
+	// Replies returns the replies sent to the entry's author, oldest first.
+	Replies(entryID int) ([]ContactReply, error)
+	AddReply(reply *ContactReply) (int, error)
+}
+
+// SpamRepository stores the spam queue: the rejected form submissions.
+type SpamRepository interface {
+	Add(report *SpamReport) (int, error)
+	// ListPage returns a page of the reports for the form, or for every
+	// form when form is "", and how many there are in all.
+	ListPage(form string, page PageQuery) ([]SpamReport, int, error)
+	Get(id int) (*SpamReport, error)
+	Delete(id int) error
+	// Clear deletes the reports for the form, or every report when form
+	// is "".
+	Clear(form string) error
+}
+
+// OutboxRepository stores the email waiting to be sent.
+type OutboxRepository interface {
+	Enqueue(msg *OutboxMessage) (int, error)
+	// Due returns up to limit pending messages whose next attempt is due,
+	// oldest first.
+	Due(now time.Time, limit int) ([]OutboxMessage, error)
+	// Claim reserves a due message until the given time. It reports false
+	// when the message is no longer due, having been claimed by another
+	// sender.
+	Claim(id int, now, until time.Time) (bool, error)
+	MarkSent(id int, at time.Time) error
+	// Retry records a failed attempt and when to try again.
+	Retry(id, attempts int, lastError string, next time.Time) error
+	// Fail records the last failed attempt and gives up on the message.
+	Fail(id, attempts int, lastError string) error
+}
+
+// RoleRepository stores the permission bundle of every role.
+type RoleRepository interface {
+	All() (map[string][]string, error)
+	Save(role string, perms []string) error
+}
+
+// PostFilter narrows the posts returned by PostRepository.ListPage.
+type PostFilter struct {
+	Query    string
+	AuthorID int
+	Status   string
+	// CategoryIDs matches posts in any of the categories.
+	CategoryIDs []int
+	// Tag matches posts carrying the tag with this slug.
+	Tag string
+	// VisibleTo, when set, matches published posts and any post by this
+	// user.
+	VisibleTo int
+}
+
+// Post sort keys accepted by PostRepository.ListPage. A leading "-" sorts in
+// descending order.
+var postSortKeys = []string{"id", "-id", "title", "-title"}
+
+// Repositories bundles the storage backends the server depends on.
+type Repositories struct {
+	Posts     PostRepository
+	Revisions RevisionRepository
+	Taxonomy  TaxonomyRepository
+	Users     UserRepository
+	Gallery   GalleryRepository
+	Contacts  ContactRepository
+	Outbox    OutboxRepository
+	Spam      SpamRepository
+	Roles     RoleRepository
+	Sessions  SessionStore
+	Search    Searcher
+}
@@ -0,0 +1,1174 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newMemoryRepositories returns empty in-process repositories. They are
+// meant for tests and for trying the application without a database.
+func newMemoryRepositories() *Repositories {
+	taxonomy := &memoryTaxonomyRepository{
+		postCategories: make(map[int][]int),
+		postTags:       make(map[int][]int),
+	}
+	return &Repositories{
+		Posts:     &memoryPostRepository{taxonomy: taxonomy},
+		Revisions: &memoryRevisionRepository{},
+		Taxonomy:  taxonomy,
+		Users:     &memoryUserRepository{},
+		Gallery:   &memoryGalleryRepository{},
+		Contacts:  &memoryContactRepository{},
+		Outbox:    &memoryOutboxRepository{},
+		Spam:      &memorySpamRepository{},
+		Roles:     &memoryRoleRepository{roles: make(map[string][]string)},
+		Sessions:  newMemorySessionStore(),
+		Search:    newMemorySearchIndex(),
+	}
+}
+
+type memoryPostRepository struct {
+	mu          sync.Mutex
+	posts       []Post
+	slugHistory map[string]int
+	taxonomy    *memoryTaxonomyRepository
+	nextID      int
+}
+
+func (m *memoryPostRepository) List(filter PostFilter) ([]*Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	posts := make([]*Post, 0, len(m.posts))
+	for _, p := range m.posts {
+		if filter.Query != "" && !strings.Contains(strings.ToLower(p.Title), strings.ToLower(filter.Query)) {
+			continue
+		}
+		if filter.AuthorID != 0 && p.AuthorID != filter.AuthorID {
+			continue
+		}
+		if filter.Status != "" && p.Status != filter.Status {
+			continue
+		}
+		if filter.VisibleTo != 0 && p.Status != PostPublished && p.AuthorID != filter.VisibleTo {
+			continue
+		}
+		if len(filter.CategoryIDs) > 0 && !m.taxonomy.inCategories(p.ID, filter.CategoryIDs) {
+			continue
+		}
+		if filter.Tag != "" && !m.taxonomy.hasTag(p.ID, filter.Tag) {
+			continue
+		}
+		p := p
+		posts = append(posts, &p)
+	}
+	return posts, nil
+}
+
+func (m *memoryPostRepository) ListPage(filter PostFilter, page PageQuery) ([]*Post, int, error) {
+	posts, _ := m.List(filter)
+
+	less := func(a, b *Post) bool {
+		switch page.Sort {
+		case "-id":
+			return a.ID > b.ID
+		case "title":
+			return a.Title < b.Title || (a.Title == b.Title && a.ID < b.ID)
+		case "-title":
+			return a.Title > b.Title || (a.Title == b.Title && a.ID > b.ID)
+		}
+		return a.ID < b.ID
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return less(posts[i], posts[j])
+	})
+	total := len(posts)
+
+	// Start after the cursor, if any
+	if page.After != nil {
+		after := &Post{ID: page.After.ID, Title: page.After.Value}
+		posts = posts[sort.Search(len(posts), func(i int) bool {
+			return less(after, posts[i])
+		}):]
+	}
+	return postPage(posts, page), total, nil
+}
+
+func (m *memoryPostRepository) Get(id int) (*Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.posts {
+		if p.ID == id {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryPostRepository) GetBySlug(slug string) (*Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.posts {
+		if p.Slug == slug {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryPostRepository) SlugRedirect(slug string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.slugHistory[slug]; ok {
+		return id, nil
+	}
+	return 0, ErrNotFound
+}
+
+func (m *memoryPostRepository) Create(post *Post) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	post.ID = m.nextID
+	m.posts = append(m.posts, *post)
+	return post.ID, nil
+}
+
+func (m *memoryPostRepository) Update(post *Post) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.posts {
+		if p.ID == post.ID {
+			if p.Slug != post.Slug {
+				if m.slugHistory == nil {
+					m.slugHistory = make(map[string]int)
+				}
+				delete(m.slugHistory, post.Slug)
+				m.slugHistory[p.Slug] = p.ID
+			}
+			m.posts[i].Title = post.Title
+			m.posts[i].Slug = post.Slug
+			m.posts[i].Content = post.Content
+			m.posts[i].Format = post.Format
+			m.posts[i].Status = post.Status
+			m.posts[i].PublishAt = post.PublishAt
+			m.posts[i].PublishedAt = post.PublishedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryPostRepository) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.posts {
+		if p.ID == id {
+			m.posts = append(m.posts[:i], m.posts[i+1:]...)
+			for slug, postID := range m.slugHistory {
+				if postID == id {
+					delete(m.slugHistory, slug)
+				}
+			}
+			m.taxonomy.SetPostCategories(id, nil)
+			m.taxonomy.SetPostTags(id, nil)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryPostRepository) PublishDue(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for i, p := range m.posts {
+		if p.Status == PostScheduled && p.PublishAt != nil && !p.PublishAt.After(now) {
+			m.posts[i].Status = PostPublished
+			m.posts[i].PublishedAt = p.PublishAt
+			m.posts[i].PublishAt = nil
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memoryRevisionRepository struct {
+	mu        sync.Mutex
+	revisions []Revision
+	nextID    int
+}
+
+func (m *memoryRevisionRepository) List(postID int) ([]Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revisions []Revision
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if m.revisions[i].PostID == postID {
+			revisions = append(revisions, m.revisions[i])
+		}
+	}
+	return revisions, nil
+}
+
+func (m *memoryRevisionRepository) Get(id int) (*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rev := range m.revisions {
+		if rev.ID == id {
+			return &rev, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryRevisionRepository) Create(rev *Revision) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	rev.ID = m.nextID
+	m.revisions = append(m.revisions, *rev)
+	return rev.ID, nil
+}
+
+type memoryTaxonomyRepository struct {
+	mu             sync.Mutex
+	categories     []Category
+	tags           []Tag
+	postCategories map[int][]int
+	postTags       map[int][]int
+	nextID         int
+}
+
+func (m *memoryTaxonomyRepository) Categories() ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	categories := append([]Category{}, m.categories...)
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+func (m *memoryTaxonomyRepository) GetCategoryBySlug(slug string) (*Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryTaxonomyRepository) CreateCategory(c *Category) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	c.ID = m.nextID
+	m.categories = append(m.categories, *c)
+	return c.ID, nil
+}
+
+func (m *memoryTaxonomyRepository) UpdateCategory(c *Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.categories {
+		if m.categories[i].ID == c.ID {
+			m.categories[i] = *c
+		}
+	}
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) DeleteCategory(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parentID := 0
+	kept := m.categories[:0]
+	for _, c := range m.categories {
+		if c.ID == id {
+			parentID = c.ParentID
+			continue
+		}
+		kept = append(kept, c)
+	}
+	m.categories = kept
+	for i := range m.categories {
+		if m.categories[i].ParentID == id {
+			m.categories[i].ParentID = parentID
+		}
+	}
+	for postID, ids := range m.postCategories {
+		m.postCategories[postID] = removeID(ids, id)
+	}
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) Tags() ([]Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tags := append([]Tag{}, m.tags...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (m *memoryTaxonomyRepository) GetTagBySlug(slug string) (*Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tags {
+		if t.Slug == slug {
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryTaxonomyRepository) CreateTag(t *Tag) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	t.ID = m.nextID
+	m.tags = append(m.tags, *t)
+	return t.ID, nil
+}
+
+func (m *memoryTaxonomyRepository) UpdateTag(t *Tag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.tags {
+		if m.tags[i].ID == t.ID {
+			m.tags[i] = *t
+		}
+	}
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) DeleteTag(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.tags[:0]
+	for _, t := range m.tags {
+		if t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	m.tags = kept
+	for postID, ids := range m.postTags {
+		m.postTags[postID] = removeID(ids, id)
+	}
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) PostCategories(postID int) ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var categories []Category
+	for _, c := range m.categories {
+		if containsID(m.postCategories[postID], c.ID) {
+			categories = append(categories, c)
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories, nil
+}
+
+func (m *memoryTaxonomyRepository) PostTags(postID int) ([]Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tags []Tag
+	for _, t := range m.tags {
+		if containsID(m.postTags[postID], t.ID) {
+			tags = append(tags, t)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+func (m *memoryTaxonomyRepository) SetPostCategories(postID int, categoryIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.postCategories[postID] = append([]int{}, categoryIDs...)
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) SetPostTags(postID int, tagIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.postTags[postID] = append([]int{}, tagIDs...)
+	return nil
+}
+
+func (m *memoryTaxonomyRepository) inCategories(postID int, categoryIDs []int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range categoryIDs {
+		if containsID(m.postCategories[postID], id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryTaxonomyRepository) hasTag(postID int, slug string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tags {
+		if t.Slug == slug {
+			return containsID(m.postTags[postID], t.ID)
+		}
+	}
+	return false
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func removeID(ids []int, id int) []int {
+	kept := make([]int, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+type memoryUserRepository struct {
+	mu     sync.Mutex
+	users  []User
+	nextID int
+}
+
+func (m *memoryUserRepository) List() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := append([]User{}, m.users...)
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (m *memoryUserRepository) GetByUsername(username string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryUserRepository) Create(user *User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	user.ID = m.nextID
+	m.users = append(m.users, *user)
+	return user.ID, nil
+}
+
+func (m *memoryUserRepository) UpdatePassword(id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Password = hash
+		}
+	}
+	return nil
+}
+
+func (m *memoryUserRepository) UpdateRole(id int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Role = role
+		}
+	}
+	return nil
+}
+
+type memoryGalleryRepository struct {
+	mu     sync.Mutex
+	images []Image
+	albums []Album
+	// albumImages lists the image IDs of every album in album order.
+	albumImages map[int][]int
+	derivatives map[int][]Derivative
+	nextID      int
+	nextAlbumID int
+}
+
+func (m *memoryGalleryRepository) List() ([]Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.live(), nil
+}
+
+// live returns the images that are not in the trash.
+func (m *memoryGalleryRepository) live() []Image {
+	images := make([]Image, 0, len(m.images))
+	for _, img := range m.images {
+		if img.DeletedAt == nil {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+func (m *memoryGalleryRepository) ListPage(page PageQuery) ([]Image, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	live := m.live()
+	ids := make([]int, len(live))
+	for i, img := range live {
+		ids[i] = img.ID
+	}
+	images := make([]Image, 0, len(live))
+	for _, i := range idPage(ids, page) {
+		images = append(images, live[i])
+	}
+	return images, len(live), nil
+}
+
+func (m *memoryGalleryRepository) Get(id int) (*Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(id)
+}
+
+func (m *memoryGalleryRepository) get(id int) (*Image, error) {
+	for _, img := range m.images {
+		if img.ID == id {
+			return &img, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryGalleryRepository) GetByKey(key string) (*Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, img := range m.images {
+		if img.Path == key {
+			return &img, nil
+		}
+		for _, d := range m.derivatives[img.ID] {
+			if d.Path == key {
+				return &img, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryGalleryRepository) Add(img *Image) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	img.ID = m.nextID
+	m.images = append(m.images, *img)
+	return img.ID, nil
+}
+
+func (m *memoryGalleryRepository) Update(img *Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.images {
+		if m.images[i].ID == img.ID {
+			m.images[i].AltText = img.AltText
+			m.images[i].Caption = img.Caption
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) Trash(id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.images {
+		if m.images[i].ID == id && m.images[i].DeletedAt == nil {
+			m.images[i].DeletedAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) Restore(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.images {
+		if m.images[i].ID == id {
+			m.images[i].DeletedAt = nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) Trashed() ([]Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var images []Image
+	for _, img := range m.images {
+		if img.DeletedAt != nil {
+			images = append(images, img)
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].DeletedAt.Before(*images[j].DeletedAt) })
+	return images, nil
+}
+
+func (m *memoryGalleryRepository) MarkMissing(id int, missing bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.images {
+		if m.images[i].ID == id {
+			m.images[i].FileMissing = missing
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.images[:0]
+	for _, img := range m.images {
+		if img.ID != id {
+			kept = append(kept, img)
+		}
+	}
+	m.images = kept
+	for albumID, ids := range m.albumImages {
+		m.albumImages[albumID] = removeID(ids, id)
+	}
+	delete(m.derivatives, id)
+	return nil
+}
+
+func (m *memoryGalleryRepository) Derivatives(imageID int) ([]Derivative, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Derivative(nil), m.derivatives[imageID]...), nil
+}
+
+func (m *memoryGalleryRepository) SetDerivatives(imageID int, derivatives []Derivative) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.derivatives == nil {
+		m.derivatives = make(map[int][]Derivative)
+	}
+	m.derivatives[imageID] = append([]Derivative(nil), derivatives...)
+	return nil
+}
+
+func (m *memoryGalleryRepository) Albums() ([]Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	albums := append([]Album{}, m.albums...)
+	sort.SliceStable(albums, func(i, j int) bool {
+		if albums[i].Position != albums[j].Position {
+			return albums[i].Position < albums[j].Position
+		}
+		return albums[i].Name < albums[j].Name
+	})
+	return albums, nil
+}
+
+func (m *memoryGalleryRepository) GetAlbum(id int) (*Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range m.albums {
+		if a.ID == id {
+			return &a, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryGalleryRepository) GetAlbumBySlug(slug string) (*Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range m.albums {
+		if a.Slug == slug {
+			return &a, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryGalleryRepository) CreateAlbum(a *Album) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextAlbumID++
+	a.ID = m.nextAlbumID
+	m.albums = append(m.albums, *a)
+	return a.ID, nil
+}
+
+func (m *memoryGalleryRepository) UpdateAlbum(a *Album) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.albums {
+		if m.albums[i].ID == a.ID {
+			m.albums[i] = *a
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) DeleteAlbum(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.albums[:0]
+	for _, a := range m.albums {
+		if a.ID != id {
+			kept = append(kept, a)
+		}
+	}
+	m.albums = kept
+	delete(m.albumImages, id)
+	return nil
+}
+
+func (m *memoryGalleryRepository) AlbumImages(albumID int) ([]Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	images := make([]Image, 0, len(m.albumImages[albumID]))
+	for _, id := range m.albumImages[albumID] {
+		if img, err := m.get(id); err == nil && img.DeletedAt == nil {
+			images = append(images, *img)
+		}
+	}
+	return images, nil
+}
+
+func (m *memoryGalleryRepository) AlbumPage(albumID int, page PageQuery) ([]Image, int, error) {
+	images, _ := m.AlbumImages(albumID)
+	total := len(images)
+	start, end := page.Offset, page.Offset+page.Limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return images[start:end], total, nil
+}
+
+func (m *memoryGalleryRepository) ImageAlbums(imageID int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	for albumID, imageIDs := range m.albumImages {
+		if containsID(imageIDs, imageID) {
+			ids = append(ids, albumID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (m *memoryGalleryRepository) SetImageAlbums(imageID int, albumIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.albumImages == nil {
+		m.albumImages = make(map[int][]int)
+	}
+	for albumID, ids := range m.albumImages {
+		if !containsID(albumIDs, albumID) {
+			m.albumImages[albumID] = removeID(ids, imageID)
+		}
+	}
+	for _, albumID := range albumIDs {
+		if !containsID(m.albumImages[albumID], imageID) {
+			m.albumImages[albumID] = append(m.albumImages[albumID], imageID)
+		}
+	}
+	return nil
+}
+
+func (m *memoryGalleryRepository) OrderAlbum(albumID int, imageIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ordered := make([]int, 0, len(m.albumImages[albumID]))
+	for _, id := range imageIDs {
+		if containsID(m.albumImages[albumID], id) && !containsID(ordered, id) {
+			ordered = append(ordered, id)
+		}
+	}
+	// Images left out keep their place after the ordered ones
+	for _, id := range m.albumImages[albumID] {
+		if !containsID(ordered, id) {
+			ordered = append(ordered, id)
+		}
+	}
+	m.albumImages[albumID] = ordered
+	return nil
+}
+
+type memoryContactRepository struct {
+	mu      sync.Mutex
+	entries []ContactEntry
+	nextID  int
+	notes   []ContactNote
+	replies []ContactReply
+}
+
+func (m *memoryContactRepository) List() ([]ContactEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ContactEntry{}, m.entries...), nil
+}
+
+func (m *memoryContactRepository) ListPage(filter ContactFilter, page PageQuery) ([]ContactEntry, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	var matching []int
+	for i, entry := range m.entries {
+		if len(filter.Statuses) > 0 && !containsString(filter.Statuses, entry.Status) {
+			continue
+		}
+		if filter.AssigneeID != 0 && entry.AssigneeID != filter.AssigneeID {
+			continue
+		}
+		ids = append(ids, entry.ID)
+		matching = append(matching, i)
+	}
+	entries := make([]ContactEntry, 0, len(ids))
+	for _, i := range idPage(ids, page) {
+		entries = append(entries, m.entries[matching[i]])
+	}
+	return entries, len(ids), nil
+}
+
+func (m *memoryContactRepository) Get(id int) (*ContactEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.entries {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryContactRepository) Create(entry *ContactEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	entry.ID = m.nextID
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+func (m *memoryContactRepository) SetStatus(id int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.entries {
+		if m.entries[i].ID == id {
+			m.entries[i].Status = status
+		}
+	}
+	return nil
+}
+
+func (m *memoryContactRepository) Assign(id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.entries {
+		if m.entries[i].ID == id {
+			m.entries[i].AssigneeID = userID
+		}
+	}
+	return nil
+}
+
+func (m *memoryContactRepository) StatusCounts() (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]int)
+	for _, entry := range m.entries {
+		counts[entry.Status]++
+	}
+	return counts, nil
+}
+
+func (m *memoryContactRepository) Notes(entryID int) ([]ContactNote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var notes []ContactNote
+	for _, note := range m.notes {
+		if note.EntryID == entryID {
+			notes = append(notes, note)
+		}
+	}
+	return notes, nil
+}
+
+func (m *memoryContactRepository) AddNote(note *ContactNote) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	note.ID = len(m.notes) + 1
+	m.notes = append(m.notes, *note)
+	return note.ID, nil
+}
+
+func (m *memoryContactRepository) Replies(entryID int) ([]ContactReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var replies []ContactReply
+	for _, reply := range m.replies {
+		if reply.EntryID == entryID {
+			replies = append(replies, reply)
+		}
+	}
+	return replies, nil
+}
+
+func (m *memoryContactRepository) AddReply(reply *ContactReply) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reply.ID = len(m.replies) + 1
+	m.replies = append(m.replies, *reply)
+	return reply.ID, nil
+}
+
+type memorySpamRepository struct {
+	mu      sync.Mutex
+	reports []SpamReport
+	nextID  int
+}
+
+func (m *memorySpamRepository) Add(report *SpamReport) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	report.ID = m.nextID
+	m.reports = append(m.reports, *report)
+	return report.ID, nil
+}
+
+func (m *memorySpamRepository) ListPage(form string, page PageQuery) ([]SpamReport, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	var matching []int
+	for i, report := range m.reports {
+		if form == "" || report.Form == form {
+			ids = append(ids, report.ID)
+			matching = append(matching, i)
+		}
+	}
+	reports := make([]SpamReport, 0, len(ids))
+	for _, i := range idPage(ids, page) {
+		reports = append(reports, m.reports[matching[i]])
+	}
+	return reports, len(ids), nil
+}
+
+func (m *memorySpamRepository) Get(id int) (*SpamReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, report := range m.reports {
+		if report.ID == id {
+			return &report, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memorySpamRepository) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.reports[:0]
+	for _, report := range m.reports {
+		if report.ID != id {
+			kept = append(kept, report)
+		}
+	}
+	m.reports = kept
+	return nil
+}
+
+func (m *memorySpamRepository) Clear(form string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.reports[:0]
+	for _, report := range m.reports {
+		if form != "" && report.Form != form {
+			kept = append(kept, report)
+		}
+	}
+	m.reports = kept
+	return nil
+}
+
+type memoryOutboxRepository struct {
+	mu       sync.Mutex
+	messages []OutboxMessage
+}
+
+func (m *memoryOutboxRepository) Enqueue(msg *OutboxMessage) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg.ID = len(m.messages) + 1
+	m.messages = append(m.messages, *msg)
+	return msg.ID, nil
+}
+
+func (m *memoryOutboxRepository) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []OutboxMessage
+	for _, msg := range m.messages {
+		if msg.Status == OutboxPending && !msg.NextAttemptAt.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+// message returns the stored message with the ID, or nil.
+func (m *memoryOutboxRepository) message(id int) *OutboxMessage {
+	if id < 1 || id > len(m.messages) {
+		return nil
+	}
+	return &m.messages[id-1]
+}
+
+func (m *memoryOutboxRepository) Claim(id int, now, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.message(id)
+	if msg == nil || msg.Status != OutboxPending || msg.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	msg.NextAttemptAt = until
+	return true, nil
+}
+
+func (m *memoryOutboxRepository) MarkSent(id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg := m.message(id); msg != nil {
+		msg.Status = OutboxSent
+		msg.Attempts++
+		msg.LastError = ""
+		msg.SentAt = &at
+	}
+	return nil
+}
+
+func (m *memoryOutboxRepository) Retry(id, attempts int, lastError string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg := m.message(id); msg != nil {
+		msg.Attempts = attempts
+		msg.LastError = lastError
+		msg.NextAttemptAt = next
+	}
+	return nil
+}
+
+func (m *memoryOutboxRepository) Fail(id, attempts int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg := m.message(id); msg != nil {
+		msg.Status = OutboxFailed
+		msg.Attempts = attempts
+		msg.LastError = lastError
+	}
+	return nil
+}
+
+type memoryRoleRepository struct {
+	mu    sync.Mutex
+	roles map[string][]string
+}
+
+func (m *memoryRoleRepository) All() (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	roles := make(map[string][]string, len(m.roles))
+	for role, perms := range m.roles {
+		roles[role] = append([]string{}, perms...)
+	}
+	return roles, nil
+}
+
+func (m *memoryRoleRepository) Save(role string, perms []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.roles[role] = append([]string{}, perms...)
+	return nil
+}
+
+// postPage returns the page of the sorted posts.
+func postPage(items []*Post, page PageQuery) []*Post {
+	start, end := page.Offset, page.Offset+page.Limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+	return append([]*Post{}, items[start:end]...)
+}
+
+// idPage sorts records by ID, as the page's id or -id sort key asks, and
+// returns the indexes of the records on the page.
+func idPage(ids []int, page PageQuery) []int {
+	order := make([]int, len(ids))
+	for i := range order {
+		order[i] = i
+	}
+	desc := page.Sort == "-id"
+	sort.Slice(order, func(i, j int) bool {
+		if desc {
+			return ids[order[i]] > ids[order[j]]
+		}
+		return ids[order[i]] < ids[order[j]]
+	})
+
+	// Start after the cursor, if any
+	if page.After != nil {
+		order = order[sort.Search(len(order), func(i int) bool {
+			if desc {
+				return ids[order[i]] < page.After.ID
+			}
+			return ids[order[i]] > page.After.ID
+		}):]
+	}
+
+	start, end := page.Offset, page.Offset+page.Limit
+	if start > len(order) {
+		start = len(order)
+	}
+	if end > len(order) {
+		end = len(order)
+	}
+	return order[start:end]
+}
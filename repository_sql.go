@@ -0,0 +1,1430 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// newSQLRepositories returns repositories backed by the given database. The
+// schema is managed by the migrations in the migrations directory.
+func newSQLRepositories(db *sql.DB, d dialect) *Repositories {
+	// Only MySQL has a full-text index; SQLite uses the in-process one
+	var search Searcher = newMemorySearchIndex()
+	if d.name() == "mysql" {
+		search = &mysqlSearcher{db: db}
+	}
+
+	return &Repositories{
+		Posts:     &sqlPostRepository{db: db},
+		Revisions: &sqlRevisionRepository{db: db},
+		Taxonomy:  &sqlTaxonomyRepository{db: db},
+		Users:     &sqlUserRepository{db: db},
+		Gallery:   &sqlGalleryRepository{db: db},
+		Contacts:  &sqlContactRepository{db: db},
+		Outbox:    &sqlOutboxRepository{db: db},
+		Spam:      &sqlSpamRepository{db: db},
+		Roles:     &sqlRoleRepository{db: db, dialect: d},
+		Sessions:  newSQLSessionStore(db),
+		Search:    search,
+	}
+}
+
+type sqlPostRepository struct {
+	db *sql.DB
+}
+
+const postColumns = "id, title, slug, content, format, author_id, status, publish_at, published_at"
+
+func (m *sqlPostRepository) List(filter PostFilter) ([]*Post, error) {
+	cond, args := postWhere(filter)
+
+	// Prepare the SQL statement
+	rows, err := m.db.Query("SELECT "+postColumns+" FROM posts WHERE "+cond+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+func (m *sqlPostRepository) ListPage(filter PostFilter, page PageQuery) ([]*Post, int, error) {
+	cond, args := postWhere(filter)
+
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM posts WHERE "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// Start after the cursor, if any
+	if page.After != nil {
+		after, afterArgs := keysetWhere(page.After)
+		cond += " AND " + after
+		args = append(args, afterArgs...)
+	}
+
+	rows, err := m.db.Query(
+		"SELECT "+postColumns+" FROM posts WHERE "+cond+" ORDER BY "+orderBy(page.Sort)+" LIMIT ? OFFSET ?",
+		append(args, page.Limit, page.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return posts, total, nil
+}
+
+func postWhere(filter PostFilter) (string, []interface{}) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if filter.Query != "" {
+		where = append(where, "title LIKE ?")
+		args = append(args, "%"+filter.Query+"%")
+	}
+	if filter.AuthorID != 0 {
+		where = append(where, "author_id = ?")
+		args = append(args, filter.AuthorID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.VisibleTo != 0 {
+		where = append(where, "(status = ? OR author_id = ?)")
+		args = append(args, PostPublished, filter.VisibleTo)
+	}
+	if len(filter.CategoryIDs) > 0 {
+		where = append(where, "id IN (SELECT post_id FROM post_categories WHERE category_id IN ("+placeholders(len(filter.CategoryIDs))+"))")
+		for _, id := range filter.CategoryIDs {
+			args = append(args, id)
+		}
+	}
+	if filter.Tag != "" {
+		where = append(where, "id IN (SELECT pt.post_id FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE t.slug = ?)")
+		args = append(args, filter.Tag)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// placeholders returns n comma separated "?" placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// orderBy maps a sort key to an ORDER BY clause. Keys name a column,
+// with a leading "-" for descending order, and ties are broken by id.
+// Keys must have been checked against the listing's sort keys.
+func orderBy(sort string) string {
+	column, dir := sortColumn(sort)
+	if column == "id" {
+		return "id " + dir
+	}
+	return column + " " + dir + ", id " + dir
+}
+
+// keysetWhere returns the condition selecting the rows that follow the
+// cursor in its sort order.
+func keysetWhere(c *Cursor) (string, []interface{}) {
+	column, dir := sortColumn(c.Sort)
+	op := ">"
+	if dir == "DESC" {
+		op = "<"
+	}
+	if column == "id" {
+		return "id " + op + " ?", []interface{}{c.ID}
+	}
+	return "(" + column + " " + op + " ? OR (" + column + " = ? AND id " + op + " ?))", []interface{}{c.Value, c.Value, c.ID}
+}
+
+func sortColumn(sort string) (string, string) {
+	if strings.HasPrefix(sort, "-") {
+		return sort[1:], "DESC"
+	}
+	if sort == "" {
+		return "id", "ASC"
+	}
+	return sort, "ASC"
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPost(row rowScanner) (*Post, error) {
+	var post Post
+	var publishAt, publishedAt sql.NullTime
+	err := row.Scan(&post.ID, &post.Title, &post.Slug, &post.Content, &post.Format, &post.AuthorID, &post.Status, &publishAt, &publishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if publishAt.Valid {
+		post.PublishAt = &publishAt.Time
+	}
+	if publishedAt.Valid {
+		post.PublishedAt = &publishedAt.Time
+	}
+	return &post, nil
+}
+
+func scanPosts(rows *sql.Rows) ([]*Post, error) {
+	// Create a slice to hold the retrieved posts
+	posts := make([]*Post, 0)
+
+	// Iterate over the rows
+	for rows.Next() {
+		// Scan the row values into a new Post struct
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		// Append the post to the slice
+		posts = append(posts, post)
+	}
+
+	// Check for any errors during iteration
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (m *sqlPostRepository) Get(id int) (*Post, error) {
+	// Prepare the SQL statement
+	stmt, err := m.db.Prepare("SELECT " + postColumns + " FROM posts WHERE id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	// Execute the SQL statement and retrieve the post
+	post, err := scanPost(stmt.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return post, nil
+}
+
+func (m *sqlPostRepository) GetBySlug(slug string) (*Post, error) {
+	post, err := scanPost(m.db.QueryRow("SELECT "+postColumns+" FROM posts WHERE slug = ?", slug))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+func (m *sqlPostRepository) SlugRedirect(slug string) (int, error) {
+	var postID int
+	err := m.db.QueryRow("SELECT post_id FROM post_slug_history WHERE slug = ?", slug).Scan(&postID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	return postID, nil
+}
+
+func (m *sqlPostRepository) Create(post *Post) (int, error) {
+	// Prepare the SQL statement
+	stmt, err := m.db.Prepare("INSERT INTO posts (title, slug, content, format, author_id, status, publish_at, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	// Execute the SQL statement
+	res, err := stmt.Exec(post.Title, post.Slug, post.Content, post.Format, post.AuthorID, post.Status, post.PublishAt, post.PublishedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// Return the ID of the new post
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	post.ID = int(id)
+	return post.ID, nil
+}
+
+func (m *sqlPostRepository) Update(post *Post) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Remember the old slug when it changes
+	var oldSlug string
+	if err := tx.QueryRow("SELECT slug FROM posts WHERE id = ?", post.ID).Scan(&oldSlug); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if oldSlug != post.Slug {
+		if _, err := tx.Exec("DELETE FROM post_slug_history WHERE slug = ? OR slug = ?", oldSlug, post.Slug); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO post_slug_history (slug, post_id, changed_at) VALUES (?, ?, ?)", oldSlug, post.ID, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		"UPDATE posts SET title = ?, slug = ?, content = ?, format = ?, status = ?, publish_at = ?, published_at = ? WHERE id = ?",
+		post.Title, post.Slug, post.Content, post.Format, post.Status, post.PublishAt, post.PublishedAt, post.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *sqlPostRepository) Delete(id int) error {
+	// Prepare the SQL statement
+	stmt, err := m.db.Prepare("DELETE FROM posts WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// Execute the SQL statement
+	_, err = stmt.Exec(id)
+	if err != nil {
+		return err
+	}
+
+	// Free the post's old slugs and drop its taxonomy links
+	for _, table := range []string{"post_slug_history", "post_categories", "post_tags"} {
+		if _, err := m.db.Exec("DELETE FROM "+table+" WHERE post_id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *sqlPostRepository) PublishDue(now time.Time) (int, error) {
+	res, err := m.db.Exec(
+		"UPDATE posts SET status = ?, published_at = publish_at, publish_at = NULL WHERE status = ? AND publish_at <= ?",
+		PostPublished, PostScheduled, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+type sqlRevisionRepository struct {
+	db *sql.DB
+}
+
+func (m *sqlRevisionRepository) List(postID int) ([]Revision, error) {
+	rows, err := m.db.Query("SELECT id, post_id, author_id, created_at, title, content, format, note FROM post_revisions WHERE post_id = ? ORDER BY id DESC", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.ID, &rev.PostID, &rev.AuthorID, &rev.CreatedAt, &rev.Title, &rev.Content, &rev.Format, &rev.Note); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m *sqlRevisionRepository) Get(id int) (*Revision, error) {
+	row := m.db.QueryRow("SELECT id, post_id, author_id, created_at, title, content, format, note FROM post_revisions WHERE id = ?", id)
+
+	var rev Revision
+	err := row.Scan(&rev.ID, &rev.PostID, &rev.AuthorID, &rev.CreatedAt, &rev.Title, &rev.Content, &rev.Format, &rev.Note)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &rev, nil
+}
+
+func (m *sqlRevisionRepository) Create(rev *Revision) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO post_revisions (post_id, author_id, created_at, title, content, format, note) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		rev.PostID, rev.AuthorID, rev.CreatedAt, rev.Title, rev.Content, rev.Format, rev.Note,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	rev.ID = int(id)
+	return rev.ID, nil
+}
+
+type sqlTaxonomyRepository struct {
+	db *sql.DB
+}
+
+func scanCategories(rows *sql.Rows, err error) ([]Category, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var c Category
+		var parentID sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &parentID); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			c.ParentID = int(parentID.Int64)
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func scanTags(rows *sql.Rows, err error) ([]Tag, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// nullID stores a zero ID as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+func (m *sqlTaxonomyRepository) Categories() ([]Category, error) {
+	return scanCategories(m.db.Query("SELECT id, name, slug, parent_id FROM categories ORDER BY name"))
+}
+
+func (m *sqlTaxonomyRepository) GetCategoryBySlug(slug string) (*Category, error) {
+	categories, err := scanCategories(m.db.Query("SELECT id, name, slug, parent_id FROM categories WHERE slug = ?", slug))
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, ErrNotFound
+	}
+	return &categories[0], nil
+}
+
+func (m *sqlTaxonomyRepository) CreateCategory(c *Category) (int, error) {
+	res, err := m.db.Exec("INSERT INTO categories (name, slug, parent_id) VALUES (?, ?, ?)", c.Name, c.Slug, nullID(c.ParentID))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	c.ID = int(id)
+	return c.ID, nil
+}
+
+func (m *sqlTaxonomyRepository) UpdateCategory(c *Category) error {
+	_, err := m.db.Exec("UPDATE categories SET name = ?, slug = ?, parent_id = ? WHERE id = ?", c.Name, c.Slug, nullID(c.ParentID), c.ID)
+	return err
+}
+
+func (m *sqlTaxonomyRepository) DeleteCategory(id int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	if err := tx.QueryRow("SELECT parent_id FROM categories WHERE id = ?", id).Scan(&parentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if _, err := tx.Exec("UPDATE categories SET parent_id = ? WHERE parent_id = ?", parentID, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *sqlTaxonomyRepository) Tags() ([]Tag, error) {
+	return scanTags(m.db.Query("SELECT id, name, slug FROM tags ORDER BY name"))
+}
+
+func (m *sqlTaxonomyRepository) GetTagBySlug(slug string) (*Tag, error) {
+	tags, err := scanTags(m.db.Query("SELECT id, name, slug FROM tags WHERE slug = ?", slug))
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, ErrNotFound
+	}
+	return &tags[0], nil
+}
+
+func (m *sqlTaxonomyRepository) CreateTag(t *Tag) (int, error) {
+	res, err := m.db.Exec("INSERT INTO tags (name, slug) VALUES (?, ?)", t.Name, t.Slug)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	t.ID = int(id)
+	return t.ID, nil
+}
+
+func (m *sqlTaxonomyRepository) UpdateTag(t *Tag) error {
+	_, err := m.db.Exec("UPDATE tags SET name = ?, slug = ? WHERE id = ?", t.Name, t.Slug, t.ID)
+	return err
+}
+
+func (m *sqlTaxonomyRepository) DeleteTag(id int) error {
+	if _, err := m.db.Exec("DELETE FROM post_tags WHERE tag_id = ?", id); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("DELETE FROM tags WHERE id = ?", id)
+	return err
+}
+
+func (m *sqlTaxonomyRepository) PostCategories(postID int) ([]Category, error) {
+	return scanCategories(m.db.Query(
+		"SELECT c.id, c.name, c.slug, c.parent_id FROM categories c JOIN post_categories pc ON pc.category_id = c.id WHERE pc.post_id = ? ORDER BY c.name",
+		postID,
+	))
+}
+
+func (m *sqlTaxonomyRepository) PostTags(postID int) ([]Tag, error) {
+	return scanTags(m.db.Query(
+		"SELECT t.id, t.name, t.slug FROM tags t JOIN post_tags pt ON pt.tag_id = t.id WHERE pt.post_id = ? ORDER BY t.name",
+		postID,
+	))
+}
+
+func (m *sqlTaxonomyRepository) SetPostCategories(postID int, categoryIDs []int) error {
+	return m.setLinks("post_categories", "category_id", postID, categoryIDs)
+}
+
+func (m *sqlTaxonomyRepository) SetPostTags(postID int, tagIDs []int) error {
+	return m.setLinks("post_tags", "tag_id", postID, tagIDs)
+}
+
+// setLinks replaces the rows of a post link table.
+func (m *sqlTaxonomyRepository) setLinks(table, column string, postID int, ids []int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM "+table+" WHERE post_id = ?", postID); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO "+table+" (post_id, "+column+") VALUES (?, ?)", postID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlUserRepository struct {
+	db *sql.DB
+}
+
+func (m *sqlUserRepository) List() ([]User, error) {
+	rows, err := m.db.Query("SELECT id, name, email, username, role FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Username, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *sqlUserRepository) GetByUsername(username string) (*User, error) {
+	query := "SELECT id, name, email, username, password, role FROM users WHERE username = ?"
+	row := m.db.QueryRow(query, username)
+
+	var user User
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Username, &user.Password, &user.Role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (m *sqlUserRepository) Create(user *User) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO users (name, username, email, password, role) VALUES (?, ?, ?, ?, ?)",
+		user.Name, user.Username, user.Email, user.Password, user.Role,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	user.ID = int(id)
+	return user.ID, nil
+}
+
+func (m *sqlUserRepository) UpdatePassword(id int, hash string) error {
+	_, err := m.db.Exec("UPDATE users SET password = ? WHERE id = ?", hash, id)
+	return err
+}
+
+func (m *sqlUserRepository) UpdateRole(id int, role string) error {
+	_, err := m.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+type sqlGalleryRepository struct {
+	db *sql.DB
+}
+
+const imageColumns = "id, filename, path, mime_type, size, width, height, checksum, uploader_id, alt_text, caption, created_at, deleted_at, file_missing"
+
+func scanImages(rows *sql.Rows, err error) ([]Image, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make([]Image, 0)
+	for rows.Next() {
+		var img Image
+		var deletedAt sql.NullTime
+		err := rows.Scan(&img.ID, &img.Filename, &img.Path, &img.MIMEType, &img.Size, &img.Width, &img.Height,
+			&img.Checksum, &img.UploaderID, &img.AltText, &img.Caption, &img.CreatedAt, &deletedAt, &img.FileMissing)
+		if err != nil {
+			return nil, err
+		}
+		if deletedAt.Valid {
+			img.DeletedAt = &deletedAt.Time
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+func (m *sqlGalleryRepository) List() ([]Image, error) {
+	return scanImages(m.db.Query("SELECT " + imageColumns + " FROM gallery WHERE deleted_at IS NULL ORDER BY id"))
+}
+
+func (m *sqlGalleryRepository) ListPage(page PageQuery) ([]Image, int, error) {
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM gallery WHERE deleted_at IS NULL").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	cond, args := "1 = 1", []interface{}{}
+	if page.After != nil {
+		cond, args = keysetWhere(page.After)
+	}
+	images, err := scanImages(m.db.Query(
+		"SELECT "+imageColumns+" FROM gallery WHERE deleted_at IS NULL AND "+cond+" ORDER BY "+orderBy(page.Sort)+" LIMIT ? OFFSET ?",
+		append(args, page.Limit, page.Offset)...,
+	))
+	if err != nil {
+		return nil, 0, err
+	}
+	return images, total, nil
+}
+
+func (m *sqlGalleryRepository) Get(id int) (*Image, error) {
+	images, err := scanImages(m.db.Query("SELECT "+imageColumns+" FROM gallery WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, ErrNotFound
+	}
+	return &images[0], nil
+}
+
+func (m *sqlGalleryRepository) GetByKey(key string) (*Image, error) {
+	images, err := scanImages(m.db.Query(
+		"SELECT "+imageColumns+" FROM gallery WHERE path = ? OR id IN (SELECT image_id FROM image_derivatives WHERE path = ?)",
+		key, key,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, ErrNotFound
+	}
+	return &images[0], nil
+}
+
+func (m *sqlGalleryRepository) Add(img *Image) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO gallery (filename, path, mime_type, size, width, height, checksum, uploader_id, alt_text, caption, created_at)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		img.Filename, img.Path, img.MIMEType, img.Size, img.Width, img.Height,
+		img.Checksum, img.UploaderID, img.AltText, img.Caption, img.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	img.ID = int(id)
+	return img.ID, nil
+}
+
+func (m *sqlGalleryRepository) Update(img *Image) error {
+	_, err := m.db.Exec("UPDATE gallery SET alt_text = ?, caption = ? WHERE id = ?", img.AltText, img.Caption, img.ID)
+	return err
+}
+
+func (m *sqlGalleryRepository) Trash(id int, at time.Time) error {
+	_, err := m.db.Exec("UPDATE gallery SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", at, id)
+	return err
+}
+
+func (m *sqlGalleryRepository) Restore(id int) error {
+	_, err := m.db.Exec("UPDATE gallery SET deleted_at = NULL WHERE id = ?", id)
+	return err
+}
+
+func (m *sqlGalleryRepository) Trashed() ([]Image, error) {
+	return scanImages(m.db.Query("SELECT " + imageColumns + " FROM gallery WHERE deleted_at IS NOT NULL ORDER BY deleted_at, id"))
+}
+
+func (m *sqlGalleryRepository) MarkMissing(id int, missing bool) error {
+	_, err := m.db.Exec("UPDATE gallery SET file_missing = ? WHERE id = ?", missing, id)
+	return err
+}
+
+func (m *sqlGalleryRepository) Delete(id int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM album_images WHERE image_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM image_derivatives WHERE image_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM gallery WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *sqlGalleryRepository) Derivatives(imageID int) ([]Derivative, error) {
+	rows, err := m.db.Query(
+		"SELECT name, path, mime_type, width, height, size FROM image_derivatives WHERE image_id = ? ORDER BY width, mime_type",
+		imageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var derivatives []Derivative
+	for rows.Next() {
+		var d Derivative
+		if err := rows.Scan(&d.Name, &d.Path, &d.MIMEType, &d.Width, &d.Height, &d.Size); err != nil {
+			return nil, err
+		}
+		derivatives = append(derivatives, d)
+	}
+	return derivatives, rows.Err()
+}
+
+func (m *sqlGalleryRepository) SetDerivatives(imageID int, derivatives []Derivative) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM image_derivatives WHERE image_id = ?", imageID); err != nil {
+		return err
+	}
+	for _, d := range derivatives {
+		_, err := tx.Exec(
+			"INSERT INTO image_derivatives (image_id, name, path, mime_type, width, height, size) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			imageID, d.Name, d.Path, d.MIMEType, d.Width, d.Height, d.Size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanAlbums(rows *sql.Rows, err error) ([]Album, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := make([]Album, 0)
+	for rows.Next() {
+		var a Album
+		if err := rows.Scan(&a.ID, &a.Name, &a.Slug, &a.Position); err != nil {
+			return nil, err
+		}
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+func (m *sqlGalleryRepository) Albums() ([]Album, error) {
+	return scanAlbums(m.db.Query("SELECT id, name, slug, position FROM albums ORDER BY position, name"))
+}
+
+func (m *sqlGalleryRepository) GetAlbum(id int) (*Album, error) {
+	return firstAlbum(scanAlbums(m.db.Query("SELECT id, name, slug, position FROM albums WHERE id = ?", id)))
+}
+
+func (m *sqlGalleryRepository) GetAlbumBySlug(slug string) (*Album, error) {
+	return firstAlbum(scanAlbums(m.db.Query("SELECT id, name, slug, position FROM albums WHERE slug = ?", slug)))
+}
+
+func firstAlbum(albums []Album, err error) (*Album, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(albums) == 0 {
+		return nil, ErrNotFound
+	}
+	return &albums[0], nil
+}
+
+func (m *sqlGalleryRepository) CreateAlbum(a *Album) (int, error) {
+	res, err := m.db.Exec("INSERT INTO albums (name, slug, position) VALUES (?, ?, ?)", a.Name, a.Slug, a.Position)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	a.ID = int(id)
+	return a.ID, nil
+}
+
+func (m *sqlGalleryRepository) UpdateAlbum(a *Album) error {
+	_, err := m.db.Exec("UPDATE albums SET name = ?, slug = ?, position = ? WHERE id = ?", a.Name, a.Slug, a.Position, a.ID)
+	return err
+}
+
+func (m *sqlGalleryRepository) DeleteAlbum(id int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM album_images WHERE album_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM albums WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// albumImagesFrom selects the images of an album in album order.
+const albumImagesFrom = " FROM gallery JOIN album_images ai ON ai.image_id = gallery.id" +
+	" WHERE ai.album_id = ? AND gallery.deleted_at IS NULL ORDER BY ai.position, gallery.id"
+
+func (m *sqlGalleryRepository) AlbumImages(albumID int) ([]Image, error) {
+	return scanImages(m.db.Query("SELECT "+albumImageColumns()+albumImagesFrom, albumID))
+}
+
+func (m *sqlGalleryRepository) AlbumPage(albumID int, page PageQuery) ([]Image, int, error) {
+	var total int
+	err := m.db.QueryRow(
+		"SELECT COUNT(*) FROM album_images ai JOIN gallery ON gallery.id = ai.image_id WHERE ai.album_id = ? AND gallery.deleted_at IS NULL",
+		albumID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	images, err := scanImages(m.db.Query("SELECT "+albumImageColumns()+albumImagesFrom+" LIMIT ? OFFSET ?", albumID, page.Limit, page.Offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	return images, total, nil
+}
+
+// albumImageColumns qualifies the image columns, which share names with
+// album_images.
+func albumImageColumns() string {
+	return "gallery." + strings.ReplaceAll(imageColumns, ", ", ", gallery.")
+}
+
+func (m *sqlGalleryRepository) ImageAlbums(imageID int) ([]int, error) {
+	rows, err := m.db.Query("SELECT album_id FROM album_images WHERE image_id = ?", imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (m *sqlGalleryRepository) SetImageAlbums(imageID int, albumIDs []int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Leave the albums that are no longer selected
+	cond, args := "image_id = ?", []interface{}{imageID}
+	if len(albumIDs) > 0 {
+		cond += " AND album_id NOT IN (" + placeholders(len(albumIDs)) + ")"
+		for _, id := range albumIDs {
+			args = append(args, id)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM album_images WHERE "+cond, args...); err != nil {
+		return err
+	}
+
+	// Join the new ones at the end
+	for _, albumID := range albumIDs {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM album_images WHERE album_id = ? AND image_id = ?", albumID, imageID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		var last int
+		if err := tx.QueryRow("SELECT COALESCE(MAX(position), 0) FROM album_images WHERE album_id = ?", albumID).Scan(&last); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO album_images (album_id, image_id, position) VALUES (?, ?, ?)", albumID, imageID, last+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *sqlGalleryRepository) OrderAlbum(albumID int, imageIDs []int) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, imageID := range imageIDs {
+		if _, err := tx.Exec("UPDATE album_images SET position = ? WHERE album_id = ? AND image_id = ?", i+1, albumID, imageID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlContactRepository struct {
+	db *sql.DB
+}
+
+const contactColumns = "id, name, email, message, received_at, status, assignee_id"
+
+func scanContacts(rows *sql.Rows, err error) ([]ContactEntry, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]ContactEntry, 0)
+	for rows.Next() {
+		var entry ContactEntry
+		err := rows.Scan(&entry.ID, &entry.Name, &entry.Email, &entry.Message, &entry.ReceivedAt, &entry.Status, &entry.AssigneeID)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (m *sqlContactRepository) List() ([]ContactEntry, error) {
+	return scanContacts(m.db.Query("SELECT " + contactColumns + " FROM contact_entries ORDER BY id"))
+}
+
+func (m *sqlContactRepository) ListPage(filter ContactFilter, page PageQuery) ([]ContactEntry, int, error) {
+	cond, args := contactWhere(filter)
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM contact_entries WHERE "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if page.After != nil {
+		after, afterArgs := keysetWhere(page.After)
+		cond += " AND " + after
+		args = append(args, afterArgs...)
+	}
+	entries, err := scanContacts(m.db.Query(
+		"SELECT "+contactColumns+" FROM contact_entries WHERE "+cond+" ORDER BY "+orderBy(page.Sort)+" LIMIT ? OFFSET ?",
+		append(args, page.Limit, page.Offset)...,
+	))
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func contactWhere(filter ContactFilter) (string, []interface{}) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if len(filter.Statuses) > 0 {
+		where = append(where, "status IN ("+placeholders(len(filter.Statuses))+")")
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if filter.AssigneeID != 0 {
+		where = append(where, "assignee_id = ?")
+		args = append(args, filter.AssigneeID)
+	}
+	return strings.Join(where, " AND "), args
+}
+
+func (m *sqlContactRepository) Get(id int) (*ContactEntry, error) {
+	entries, err := scanContacts(m.db.Query("SELECT "+contactColumns+" FROM contact_entries WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return &entries[0], nil
+}
+
+func (m *sqlContactRepository) Create(entry *ContactEntry) error {
+	res, err := m.db.Exec(
+		"INSERT INTO contact_entries (name, email, message, received_at, status, assignee_id) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.Name, entry.Email, entry.Message, entry.ReceivedAt, entry.Status, entry.AssigneeID,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+	return nil
+}
+
+func (m *sqlContactRepository) SetStatus(id int, status string) error {
+	_, err := m.db.Exec("UPDATE contact_entries SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+func (m *sqlContactRepository) Assign(id, userID int) error {
+	_, err := m.db.Exec("UPDATE contact_entries SET assignee_id = ? WHERE id = ?", userID, id)
+	return err
+}
+
+func (m *sqlContactRepository) StatusCounts() (map[string]int, error) {
+	rows, err := m.db.Query("SELECT status, COUNT(*) FROM contact_entries GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+func (m *sqlContactRepository) Notes(entryID int) ([]ContactNote, error) {
+	rows, err := m.db.Query("SELECT id, entry_id, author_id, body, created_at FROM contact_notes WHERE entry_id = ? ORDER BY id", entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []ContactNote
+	for rows.Next() {
+		var note ContactNote
+		if err := rows.Scan(&note.ID, &note.EntryID, &note.AuthorID, &note.Body, &note.CreatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+func (m *sqlContactRepository) AddNote(note *ContactNote) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO contact_notes (entry_id, author_id, body, created_at) VALUES (?, ?, ?, ?)",
+		note.EntryID, note.AuthorID, note.Body, note.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	note.ID = int(id)
+	return note.ID, nil
+}
+
+func (m *sqlContactRepository) Replies(entryID int) ([]ContactReply, error) {
+	rows, err := m.db.Query("SELECT id, entry_id, author_id, subject, body, created_at FROM contact_replies WHERE entry_id = ? ORDER BY id", entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []ContactReply
+	for rows.Next() {
+		var reply ContactReply
+		if err := rows.Scan(&reply.ID, &reply.EntryID, &reply.AuthorID, &reply.Subject, &reply.Body, &reply.CreatedAt); err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, rows.Err()
+}
+
+func (m *sqlContactRepository) AddReply(reply *ContactReply) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO contact_replies (entry_id, author_id, subject, body, created_at) VALUES (?, ?, ?, ?, ?)",
+		reply.EntryID, reply.AuthorID, reply.Subject, reply.Body, reply.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	reply.ID = int(id)
+	return reply.ID, nil
+}
+
+type sqlSpamRepository struct {
+	db *sql.DB
+}
+
+func (m *sqlSpamRepository) Add(report *SpamReport) (int, error) {
+	fields, err := json.Marshal(report.Fields)
+	if err != nil {
+		return 0, err
+	}
+	res, err := m.db.Exec(
+		"INSERT INTO spam_reports (form, ip, fields, score, reasons, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		report.Form, report.IP, string(fields), report.Score, strings.Join(report.Reasons, "\n"), report.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	report.ID = int(id)
+	return report.ID, nil
+}
+
+func scanSpamReports(rows *sql.Rows, err error) ([]SpamReport, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]SpamReport, 0)
+	for rows.Next() {
+		var report SpamReport
+		var fields, reasons string
+		if err := rows.Scan(&report.ID, &report.Form, &report.IP, &fields, &report.Score, &reasons, &report.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(fields), &report.Fields); err != nil {
+			return nil, err
+		}
+		if reasons != "" {
+			report.Reasons = strings.Split(reasons, "\n")
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (m *sqlSpamRepository) ListPage(form string, page PageQuery) ([]SpamReport, int, error) {
+	cond, args := "1 = 1", []interface{}{}
+	if form != "" {
+		cond, args = "form = ?", []interface{}{form}
+	}
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM spam_reports WHERE "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if page.After != nil {
+		after, afterArgs := keysetWhere(page.After)
+		cond += " AND " + after
+		args = append(args, afterArgs...)
+	}
+	reports, err := scanSpamReports(m.db.Query(
+		"SELECT id, form, ip, fields, score, reasons, created_at FROM spam_reports WHERE "+cond+" ORDER BY "+orderBy(page.Sort)+" LIMIT ? OFFSET ?",
+		append(args, page.Limit, page.Offset)...,
+	))
+	if err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
+
+func (m *sqlSpamRepository) Get(id int) (*SpamReport, error) {
+	reports, err := scanSpamReports(m.db.Query("SELECT id, form, ip, fields, score, reasons, created_at FROM spam_reports WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, ErrNotFound
+	}
+	return &reports[0], nil
+}
+
+func (m *sqlSpamRepository) Delete(id int) error {
+	_, err := m.db.Exec("DELETE FROM spam_reports WHERE id = ?", id)
+	return err
+}
+
+func (m *sqlSpamRepository) Clear(form string) error {
+	if form == "" {
+		_, err := m.db.Exec("DELETE FROM spam_reports")
+		return err
+	}
+	_, err := m.db.Exec("DELETE FROM spam_reports WHERE form = ?", form)
+	return err
+}
+
+type sqlOutboxRepository struct {
+	db *sql.DB
+}
+
+func (m *sqlOutboxRepository) Enqueue(msg *OutboxMessage) (int, error) {
+	res, err := m.db.Exec(
+		"INSERT INTO mail_outbox (from_addr, to_addrs, reply_to, subject, body_text, body_html, status, attempts, last_error, next_attempt_at, created_at)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		msg.From, strings.Join(msg.To, "\n"), msg.ReplyTo, msg.Subject, msg.Text, msg.HTML,
+		msg.Status, msg.Attempts, msg.LastError, msg.NextAttemptAt, msg.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	msg.ID = int(id)
+	return msg.ID, nil
+}
+
+func (m *sqlOutboxRepository) Due(now time.Time, limit int) ([]OutboxMessage, error) {
+	rows, err := m.db.Query(
+		"SELECT id, from_addr, to_addrs, reply_to, subject, body_text, body_html, status, attempts, last_error, next_attempt_at, created_at, created_at"+
+			" FROM mail_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		OutboxPending, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		var to string
+		var sentAt sql.NullTime
+		err := rows.Scan(&msg.ID, &msg.From, &to, &msg.ReplyTo, &msg.Subject, &msg.Text, &msg.HTML,
+			&msg.Status, &msg.Attempts, &msg.LastError, &msg.NextAttemptAt, &msg.CreatedAt, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		msg.To = strings.Split(to, "\n")
+		if sentAt.Valid {
+			msg.SentAt = &sentAt.Time
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (m *sqlOutboxRepository) Claim(id int, now, until time.Time) (bool, error) {
+	res, err := m.db.Exec(
+		"UPDATE mail_outbox SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at <= ?",
+		until, id, OutboxPending, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (m *sqlOutboxRepository) MarkSent(id int, at time.Time) error {
+	_, err := m.db.Exec("UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = '', created_at = ? WHERE id = ?", OutboxSent, at, id)
+	return err
+}
+
+func (m *sqlOutboxRepository) Retry(id, attempts int, lastError string, next time.Time) error {
+	_, err := m.db.Exec("UPDATE mail_outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?", attempts, lastError, next, id)
+	return err
+}
+
+func (m *sqlOutboxRepository) Fail(id, attempts int, lastError string) error {
+	_, err := m.db.Exec("UPDATE mail_outbox SET status = ?, attempts = ?, last_error = ? WHERE id = ?", OutboxFailed, attempts, lastError, id)
+	return err
+}
+
+type sqlRoleRepository struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (m *sqlRoleRepository) All() (map[string][]string, error) {
+	rows, err := m.db.Query("SELECT r.name, rp.permission FROM roles r LEFT JOIN role_permissions rp ON rp.role = r.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string][]string)
+	for rows.Next() {
+		var role string
+		var perm sql.NullString
+		if err := rows.Scan(&role, &perm); err != nil {
+			return nil, err
+		}
+		if _, ok := roles[role]; !ok {
+			roles[role] = []string{}
+		}
+		if perm.Valid {
+			roles[role] = append(roles[role], perm.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Save creates the role if needed and replaces its permission bundle.
+func (m *sqlRoleRepository) Save(role string, perms []string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.dialect.insertIgnore()+" INTO roles (name) VALUES (?)", role); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = ?", role); err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		if _, err := tx.Exec("INSERT INTO role_permissions (role, permission) VALUES (?, ?)", role, perm); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision is an immutable snapshot of a post's title and content, stored
+// every time the post is saved.
+type Revision struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"post_id"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Format    string    `json:"format"`
+	Note      string    `json:"note"`
+}
+
+// maxRevisionNote is the longest change note stored with a revision.
+const maxRevisionNote = 255
+
+// recordRevision stores the post's current title and content as a new
+// revision by authorID.
+func (s *Server) recordRevision(post *Post, authorID int, note string) error {
+	note = strings.TrimSpace(note)
+	if r := []rune(note); len(r) > maxRevisionNote {
+		note = string(r[:maxRevisionNote])
+	}
+	_, err := s.revisions.Create(&Revision{
+		PostID:    post.ID,
+		AuthorID:  authorID,
+		CreatedAt: time.Now().UTC(),
+		Title:     post.Title,
+		Content:   post.Content,
+		Format:    post.Format,
+		Note:      note,
+	})
+	return err
+}
+
+// revisionsPageData is rendered by post_revisions.html.
+type revisionsPageData struct {
+	Post      *Post
+	Revisions []Revision
+	From, To  *Revision
+	Mode      string
+	TitleDiff []diffOp
+	Diff      []diffOp
+}
+
+func (s *Server) postRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check the user may edit this post
+	q := r.URL.Query()
+	post, err := s.fetchPost(q.Get("id"))
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+	if !s.perms.can(currentUser(r), PermPostsEdit, post) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	revisions, err := s.revisions.List(post.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Compare the two requested revisions, or the latest two by default
+	data := revisionsPageData{Post: post, Revisions: revisions, Mode: q.Get("mode")}
+	if data.Mode != "words" {
+		data.Mode = "lines"
+	}
+	if q.Get("from") != "" || q.Get("to") != "" {
+		data.From = findRevision(revisions, q.Get("from"))
+		data.To = findRevision(revisions, q.Get("to"))
+		if data.From == nil || data.To == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+	} else if len(revisions) >= 2 {
+		data.From, data.To = &revisions[1], &revisions[0]
+	}
+	if data.From != nil {
+		data.TitleDiff = diffWords(data.From.Title, data.To.Title)
+		if data.Mode == "words" {
+			data.Diff = diffWords(data.From.Content, data.To.Content)
+		} else {
+			data.Diff = diffLines(data.From.Content, data.To.Content)
+		}
+	}
+
+	tpl, err := template.ParseFiles("templates/post_revisions.html")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// findRevision returns the revision with the given ID from revisions.
+func findRevision(revisions []Revision, id string) *Revision {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+	for i := range revisions {
+		if revisions[i].ID == n {
+			return &revisions[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) restoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Check the user may edit this post
+	user := currentUser(r)
+	post, err := s.fetchPost(r.FormValue("id"))
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+	if !s.perms.can(user, PermPostsEdit, post) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Look up the revision, which must belong to the same post
+	revID, _ := strconv.Atoi(r.FormValue("revision"))
+	rev, err := s.revisions.Get(revID)
+	if err == nil && rev.PostID != post.ID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+
+	// Copy the old title and content back and record it as a new revision
+	post.Title = rev.Title
+	post.Content = rev.Content
+	post.Format = rev.Format
+	if err := s.posts.Update(post); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.recordRevision(post, user.ID, fmt.Sprintf("Restored revision #%d", rev.ID)); err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.indexPost(post)
+
+	http.Redirect(w, r, "/post/revisions?id="+strconv.Itoa(post.ID), http.StatusSeeOther)
+}
+
+// Diff operation kinds
+const (
+	diffEqual  = "equal"
+	diffInsert = "insert"
+	diffDelete = "delete"
+)
+
+// diffOp is one run of unchanged, inserted or deleted text.
+type diffOp struct {
+	Kind string
+	Text string
+}
+
+// maxDiffCells bounds the size of the LCS table. Larger inputs are shown as
+// a full replacement instead.
+const maxDiffCells = 4 << 20
+
+var errDiffTooLarge = errors.New("diff too large")
+
+var wordTokenRe = regexp.MustCompile(`\s+|[^\s]+`)
+
+// diffLines diffs a and b line by line.
+func diffLines(a, b string) []diffOp {
+	return diffTokens(strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n"))
+}
+
+// diffWords diffs a and b word by word, keeping whitespace as tokens so the
+// joined output reproduces the original text.
+func diffWords(a, b string) []diffOp {
+	return diffTokens(wordTokenRe.FindAllString(a, -1), wordTokenRe.FindAllString(b, -1))
+}
+
+// diffTokens returns the edit script turning a into b, computed from the
+// longest common subsequence of the two token lists.
+func diffTokens(a, b []string) []diffOp {
+	var ops []diffOp
+	emit := func(kind, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, diffOp{Kind: kind, Text: text})
+	}
+
+	// Strip the common prefix and suffix before building the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	emit(diffEqual, strings.Join(a[:prefix], ""))
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	lcs, err := lcsTable(midA, midB)
+	if err != nil {
+		emit(diffDelete, strings.Join(midA, ""))
+		emit(diffInsert, strings.Join(midB, ""))
+	} else {
+		i, j := 0, 0
+		for i < len(midA) && j < len(midB) {
+			switch {
+			case midA[i] == midB[j]:
+				emit(diffEqual, midA[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				emit(diffDelete, midA[i])
+				i++
+			default:
+				emit(diffInsert, midB[j])
+				j++
+			}
+		}
+		emit(diffDelete, strings.Join(midA[i:], ""))
+		emit(diffInsert, strings.Join(midB[j:], ""))
+	}
+
+	emit(diffEqual, strings.Join(a[len(a)-suffix:], ""))
+	return ops
+}
+
+// lcsTable returns t where t[i][j] is the length of the longest common
+// subsequence of a[i:] and b[j:].
+func lcsTable(a, b []string) ([][]int, error) {
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return nil, errDiffTooLarge
+	}
+	t := make([][]int, len(a)+1)
+	for i := range t {
+		t[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				t[i][j] = t[i+1][j+1] + 1
+			} else if t[i+1][j] >= t[i][j+1] {
+				t[i][j] = t[i+1][j]
+			} else {
+				t[i][j] = t[i][j+1]
+			}
+		}
+	}
+	return t, nil
+}
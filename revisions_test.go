@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []diffOp
+	}{
+		{"same text", "same text", []diffOp{{diffEqual, "same text"}}},
+		{"", "new", []diffOp{{diffInsert, "new"}}},
+		{"old", "", []diffOp{{diffDelete, "old"}}},
+		{"the quick fox", "the slow fox", []diffOp{
+			{diffEqual, "the "}, {diffDelete, "quick"}, {diffInsert, "slow"}, {diffEqual, " fox"},
+		}},
+		{"a b c", "a c", []diffOp{{diffEqual, "a "}, {diffDelete, "b "}, {diffEqual, "c"}}},
+		{"a c", "a b c", []diffOp{{diffEqual, "a "}, {diffInsert, "b "}, {diffEqual, "c"}}},
+	}
+	for _, tt := range tests {
+		if got := diffWords(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diffWords(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestDiffLinesRebuilds checks the edit script turns a into b: the equal
+// and deleted runs spell a, the equal and inserted runs spell b.
+func TestDiffLinesRebuilds(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"one\ntwo\nthree\n", "one\n2\nthree\nfour\n"},
+		{"x\ny\nz", "z\ny\nx"},
+		{"", "only\nnew\n"},
+		{"a\nb\na\nb\n", "b\na\nb\na\n"},
+	}
+	for _, tt := range tests {
+		var from, to strings.Builder
+		for _, op := range diffLines(tt.a, tt.b) {
+			if op.Kind != diffInsert {
+				from.WriteString(op.Text)
+			}
+			if op.Kind != diffDelete {
+				to.WriteString(op.Text)
+			}
+		}
+		if from.String() != tt.a || to.String() != tt.b {
+			t.Errorf("diffLines(%q, %q) rebuilds %q and %q", tt.a, tt.b, from.String(), to.String())
+		}
+	}
+}
+
+func TestLCSTable(t *testing.T) {
+	a := strings.Split("ABCBDAB", "")
+	b := strings.Split("BDCABA", "")
+	lcs, err := lcsTable(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lcs[0][0] != 4 {
+		t.Errorf("LCS length = %d, want 4", lcs[0][0])
+	}
+
+	big := make([]string, 3000)
+	if _, err := lcsTable(big, big); err != errDiffTooLarge {
+		t.Errorf("err = %v, want errDiffTooLarge", err)
+	}
+}
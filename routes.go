@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// User roles
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleUser   = "user"
+)
+
+// signedIn is used as a route's Roles to accept any signed-in user.
+var signedIn = []string{}
+
+type route struct {
+	Pattern string
+	Handler http.HandlerFunc
+	// Roles lists the roles allowed to use the route. A nil slice makes the
+	// route public unless Permission is set.
+	Roles []string
+	// Permission, when set, must be held by the signed-in user in some
+	// scope. Handlers check ownership against the actual resource.
+	Permission string
+}
+
+// routes returns the application's route table.
+func (s *Server) routes() []route {
+	return []route{
+		// Public pages
+		{Pattern: "/posts", Handler: s.getPostsHandler},
+		{Pattern: "/posts/", Handler: s.postPermalinkHandler},
+		{Pattern: "/category/", Handler: s.categoryArchiveHandler},
+		{Pattern: "/tag/", Handler: s.tagArchiveHandler},
+		{Pattern: "/search", Handler: s.searchHandler},
+		{Pattern: "/gallery", Handler: s.galleryHandler},
+		{Pattern: mediaPrefix, Handler: s.mediaHandler},
+		{Pattern: staticPrefix, Handler: s.staticHandler},
+		{Pattern: "/contact", Handler: s.contactHandler},
+		{Pattern: "/contact/sent", Handler: s.contactSentHandler},
+		{Pattern: "/register", Handler: s.registerHandler},
+		{Pattern: "/login", Handler: s.loginHandler},
+		{Pattern: "/logout", Handler: s.logoutHandler},
+		{Pattern: "/", Handler: s.loginHandler},
+
+		// JSON API; write methods check the session themselves
+		{Pattern: "/api/v1/posts", Handler: s.apiPostsHandler},
+		{Pattern: "/api/v1/posts/", Handler: s.apiPostsHandler},
+		{Pattern: "/api/v1/search", Handler: s.apiSearchHandler},
+
+		// Signed-in users
+		{Pattern: "/home-usr", Handler: s.indexHandler, Roles: signedIn},
+		{Pattern: "/profile", Handler: s.getProfileHandler, Roles: signedIn},
+
+		// Staff
+		{Pattern: "/home-adm", Handler: s.homeHandler, Permission: PermDashboardAccess},
+		{Pattern: "/posts-admin", Handler: s.postsHandler, Permission: PermPostsEdit},
+		{Pattern: "/post/create", Handler: s.createPostHandler, Permission: PermPostsCreate},
+		{Pattern: "/post/edit", Handler: s.editPostHandler, Permission: PermPostsEdit},
+		{Pattern: "/post/delete", Handler: s.deletePostHandler, Permission: PermPostsDelete},
+		{Pattern: "/post/revisions", Handler: s.postRevisionsHandler, Permission: PermPostsEdit},
+		{Pattern: "/post/revisions/restore", Handler: s.restoreRevisionHandler, Permission: PermPostsEdit},
+		{Pattern: "/admin/categories", Handler: s.adminCategoriesHandler, Permission: PermTaxonomyManage},
+		{Pattern: "/admin/tags", Handler: s.adminTagsHandler, Permission: PermTaxonomyManage},
+		{Pattern: "/galery-admin", Handler: s.getImageHandler, Permission: PermGalleryUpload},
+		{Pattern: "/galery/create", Handler: s.uploadImageHandler, Permission: PermGalleryUpload},
+		{Pattern: "/galery/delete", Handler: s.deleteImageHandler, Permission: PermGalleryDelete},
+		{Pattern: "/galery/trash", Handler: s.trashHandler, Permission: PermGalleryDelete},
+		{Pattern: "/galery/update", Handler: s.updateImageHandler, Permission: PermGalleryUpload},
+		{Pattern: "/galery/albums", Handler: s.adminAlbumsHandler, Permission: PermGalleryUpload},
+		{Pattern: "/galery/album", Handler: s.arrangeAlbumHandler, Permission: PermGalleryUpload},
+		{Pattern: "/contact/list", Handler: s.getContactListHandler, Permission: PermContactsRead},
+		{Pattern: "/contact/entry", Handler: s.contactEntryHandler, Permission: PermContactsRead},
+		{Pattern: "/admin/spam", Handler: s.spamQueueHandler, Permission: PermSpamManage},
+		{Pattern: "/admin/roles", Handler: s.adminRolesHandler, Permission: PermUsersManage},
+		{Pattern: "/admin/users/role", Handler: s.adminUserRoleHandler, Permission: PermUsersManage},
+	}
+}
+
+func (s *Server) registerRoutes(mux *http.ServeMux) {
+	for _, rt := range s.routes() {
+		var h http.Handler = rt.Handler
+		if rt.Permission != "" {
+			h = s.requirePermission(rt.Permission)(h)
+		} else if rt.Roles != nil {
+			h = s.requireRoles(rt.Roles...)(h)
+		}
+		mux.Handle(rt.Pattern, h)
+	}
+}
+
+// homePathForRole returns the landing page a user is sent to after login.
+func (s *Server) homePathForRole(role string) (string, bool) {
+	if !s.perms.exists(role) {
+		return "", false
+	}
+	if s.perms.has(role, PermDashboardAccess) {
+		return "/home-adm", true
+	}
+	return "/home-usr", true
+}
+
+type sessionContextKey struct{}
+
+// sessionFromContext returns the session attached by requireRoles.
+func sessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// requireRoles only lets requests through whose session has one of the given
+// roles. With no roles, any signed-in user is accepted. Anonymous HTML
+// clients are redirected to the login page, other clients get 401; signed-in
+// users without a matching role get 403.
+func (s *Server) requireRoles(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := s.currentSession(r)
+			if err != nil {
+				if wantsHTML(r) {
+					http.Redirect(w, r, "/login", http.StatusFound)
+					return
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if len(roles) > 0 && !hasRole(sess.Role, roles) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), sessionContextKey{}, sess)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
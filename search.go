@@ -0,0 +1,508 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Search document kinds
+const (
+	SearchPost    = "post"
+	SearchImage   = "image"
+	SearchContact = "contact"
+)
+
+var searchKinds = []string{SearchPost, SearchImage, SearchContact}
+
+// SearchDocument is one searchable record. Documents are identified by Kind
+// and Ref.
+type SearchDocument struct {
+	Kind  string
+	Ref   string
+	Title string
+	Body  string
+	URL   string
+	// Access is the permission needed to find the document; empty means
+	// public. The owner, when set, can always find it.
+	Access  string
+	OwnerID int
+}
+
+// SearchQuery is a parsed search request.
+type SearchQuery struct {
+	Terms []searchTerm
+	Kind  string
+	// Permissions and UserID decide which documents the searcher may see.
+	Permissions []string
+	UserID      int
+	Limit       int
+	Offset      int
+}
+
+// searchTerm is a single word or, with several words, a phrase.
+type searchTerm []string
+
+// SearchHit is a matching document.
+type SearchHit struct {
+	SearchDocument
+	Score float64
+}
+
+// Searcher indexes documents and runs queries against them.
+type Searcher interface {
+	Index(doc SearchDocument) error
+	Remove(kind, ref string) error
+	// Search returns one page of hits, best first, and the total number
+	// of matches.
+	Search(q SearchQuery) ([]SearchHit, int, error)
+}
+
+// searchTokens splits text into lower-case words, folding accented letters
+// so "crème" matches "creme".
+func searchTokens(text string) []string {
+	var tokens []string
+	for _, span := range tokenSpans(text) {
+		tokens = append(tokens, span.token)
+	}
+	return tokens
+}
+
+// foldSearchText returns the words of text as searchTokens sees them, for
+// backends that split the text into words themselves.
+func foldSearchText(text string) string {
+	return strings.Join(searchTokens(text), " ")
+}
+
+type tokenSpan struct {
+	token      string
+	start, end int
+}
+
+// tokenSpans returns the words of text with their byte offsets.
+func tokenSpans(text string) []tokenSpan {
+	var spans []tokenSpan
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			spans = append(spans, tokenSpan{token: b.String(), start: start, end: end})
+			b.Reset()
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush(i)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		r = unicode.ToLower(r)
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	flush(len(text))
+	return spans
+}
+
+// parseSearchQuery splits q into words and "quoted phrases".
+func parseSearchQuery(q string) []searchTerm {
+	var terms []searchTerm
+	for i, part := range strings.Split(q, `"`) {
+		tokens := searchTokens(part)
+		if i%2 == 1 {
+			// Inside quotes
+			if len(tokens) > 0 {
+				terms = append(terms, searchTerm(tokens))
+			}
+			continue
+		}
+		for _, t := range tokens {
+			terms = append(terms, searchTerm{t})
+		}
+	}
+	return terms
+}
+
+// canSee reports whether a query by the given user may return doc.
+func (q SearchQuery) canSee(doc SearchDocument) bool {
+	if q.Kind != "" && doc.Kind != q.Kind {
+		return false
+	}
+	if doc.Access == "" || (doc.OwnerID != 0 && doc.OwnerID == q.UserID) {
+		return true
+	}
+	for _, perm := range q.Permissions {
+		if perm == doc.Access {
+			return true
+		}
+	}
+	return false
+}
+
+// snippetLength is the approximate length of highlighted snippets in bytes.
+const snippetLength = 200
+
+// highlightSnippet returns an escaped excerpt of text around the first
+// match, with every matched word wrapped in <mark>.
+func highlightSnippet(text string, terms []searchTerm) template.HTML {
+	words := make(map[string]bool)
+	for _, term := range terms {
+		for _, w := range term {
+			words[w] = true
+		}
+	}
+
+	spans := tokenSpans(text)
+	var marks []tokenSpan
+	for _, span := range spans {
+		if words[span.token] {
+			marks = append(marks, span)
+		}
+	}
+
+	// Centre the window on the first match
+	start := 0
+	if len(marks) > 0 && marks[0].start > snippetLength/3 {
+		start = marks[0].start - snippetLength/3
+	}
+	end := start + snippetLength
+	if end > len(text) {
+		end = len(text)
+		if end-snippetLength > 0 && end-snippetLength < start {
+			start = end - snippetLength
+		}
+	}
+	start, end = snapToWords(text, spans, start, end)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	pos := start
+	for _, m := range marks {
+		if m.start < start || m.end > end {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(text[pos:m.start]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[m.start:m.end]))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(template.HTMLEscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return template.HTML(strings.TrimSpace(b.String()))
+}
+
+// snapToWords moves start and end out of the middle of words and onto
+// rune boundaries.
+func snapToWords(text string, spans []tokenSpan, start, end int) (int, int) {
+	for _, span := range spans {
+		if span.start < start && start < span.end {
+			start = span.end
+		}
+		if span.start < end && end < span.end {
+			end = span.start
+		}
+	}
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	for end > start && end < len(text) && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// postSearchDocument describes a post for the search index. Unpublished
+// posts can only be found by staff and their author.
+func (s *Server) postSearchDocument(post *Post) SearchDocument {
+	doc := SearchDocument{
+		Kind:  SearchPost,
+		Ref:   strconv.Itoa(post.ID),
+		Title: post.Title,
+		Body:  s.renderer.PlainText(post.Format, post.Content),
+		URL:   "/posts/" + post.Slug,
+	}
+	if post.Status != PostPublished {
+		doc.URL = "/post/edit?id=" + strconv.Itoa(post.ID)
+		doc.Access = PermPostsEditAny
+		doc.OwnerID = post.AuthorID
+	}
+	return doc
+}
+
+func imageSearchDocument(img *Image) SearchDocument {
+	return SearchDocument{
+		Kind:  SearchImage,
+		Ref:   strconv.Itoa(img.ID),
+		Title: img.Alt(),
+		Body:  img.Caption + "\n" + img.Filename,
+		URL:   img.URL(),
+	}
+}
+
+func contactSearchDocument(entry *ContactEntry) SearchDocument {
+	return SearchDocument{
+		Kind:   SearchContact,
+		Ref:    strconv.Itoa(entry.ID),
+		Title:  "Message from " + entry.Name,
+		Body:   entry.Message + "\n" + entry.Email,
+		URL:    "/contact/entry?id=" + strconv.Itoa(entry.ID),
+		Access: PermContactsRead,
+	}
+}
+
+// indexPost, unindexPost and the other index hooks keep the search index in
+// step with the repositories. Index failures are logged rather than failing
+// the request; a restart rebuilds the index.
+func (s *Server) indexPost(post *Post) {
+	if err := s.search.Index(s.postSearchDocument(post)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) unindexPost(id int) {
+	if err := s.search.Remove(SearchPost, strconv.Itoa(id)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) indexImage(img *Image) {
+	if err := s.search.Index(imageSearchDocument(img)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) unindexImage(id int) {
+	if err := s.search.Remove(SearchImage, strconv.Itoa(id)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) indexContact(entry *ContactEntry) {
+	if err := s.search.Index(contactSearchDocument(entry)); err != nil {
+		log.Println(err)
+	}
+}
+
+// reindexPosts indexes every post again.
+func (s *Server) reindexPosts() error {
+	posts, err := s.posts.List(PostFilter{})
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		if err := s.search.Index(s.postSearchDocument(post)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexSearch indexes every post, image and contact message.
+func (s *Server) reindexSearch() error {
+	if err := s.reindexPosts(); err != nil {
+		return err
+	}
+
+	images, err := s.gallery.List()
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		if err := s.search.Index(imageSearchDocument(&images[i])); err != nil {
+			return err
+		}
+	}
+
+	entries, err := s.contacts.List()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := s.search.Index(contactSearchDocument(&entries[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchQueryFor builds the query for a request, limited to what the
+// signed-in user (if any) may see.
+func (s *Server) searchQueryFor(r *http.Request, terms []searchTerm, kind string, limit, offset int) SearchQuery {
+	q := SearchQuery{Terms: terms, Kind: kind, Limit: limit, Offset: offset}
+	if sess, err := s.currentSession(r); err == nil {
+		q.UserID = sess.UserID
+		for _, perm := range allPermissions {
+			if s.perms.has(sess.Role, perm) {
+				q.Permissions = append(q.Permissions, perm)
+			}
+		}
+	}
+	return q
+}
+
+func validSearchKind(kind string) bool {
+	if kind == "" {
+		return true
+	}
+	for _, k := range searchKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// searchResult is a hit as shown to users.
+type searchResult struct {
+	Kind    string        `json:"kind"`
+	Ref     string        `json:"ref"`
+	Title   string        `json:"title"`
+	URL     string        `json:"url"`
+	Snippet template.HTML `json:"snippet"`
+}
+
+func searchResults(hits []SearchHit, terms []searchTerm) []searchResult {
+	results := make([]searchResult, len(hits))
+	for i, hit := range hits {
+		text := hit.Body
+		if text == "" {
+			text = hit.Title
+		}
+		results[i] = searchResult{
+			Kind:    hit.Kind,
+			Ref:     hit.Ref,
+			Title:   hit.Title,
+			URL:     hit.URL,
+			Snippet: highlightSnippet(text, terms),
+		}
+	}
+	return results
+}
+
+type searchPageData struct {
+	Query   string
+	Kind    string
+	Kinds   []string
+	Results []searchResult
+	Pages   *Pagination
+}
+
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := searchPageData{Query: q.Get("q"), Kind: q.Get("kind"), Kinds: searchKinds}
+	if !validSearchKind(data.Kind) {
+		data.Kind = ""
+	}
+	req, err := parsePageRequest(q, searchListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	data.Pages = newPagination(r, req, 0)
+
+	// Run the query, if any
+	terms := parseSearchQuery(data.Query)
+	if len(terms) > 0 {
+		results, pages, err := s.searchPage(r, terms, data.Kind, req)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data.Results = results
+		data.Pages = pages
+	}
+
+	// Render the results
+	tpl, err := template.ParseFiles("templates/search.html", "templates/pagination.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	terms := parseSearchQuery(q.Get("q"))
+	if len(terms) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "q must contain at least one word")
+		return
+	}
+	kind := q.Get("kind")
+	if !validSearchKind(kind) {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", "kind must be one of "+strings.Join(searchKinds, ", "))
+		return
+	}
+	req, err := parsePageRequest(q, searchListing)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	results, pages, err := s.searchPage(r, terms, kind, req)
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusInternalServerError, "internal", "Internal Server Error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiList{
+		Data: results,
+		Meta: pages.meta(),
+	})
+}
+
+// searchPage runs the search and returns the requested page of results.
+func (s *Server) searchPage(r *http.Request, terms []searchTerm, kind string, req PageRequest) ([]searchResult, *Pagination, error) {
+	pq := req.Query()
+	hits, total, err := s.search.Search(s.searchQueryFor(r, terms, kind, pq.Limit, pq.Offset))
+	if err != nil {
+		return nil, nil, err
+	}
+	pages := newPagination(r, req, total)
+	return searchResults(hits[:pages.fit(len(hits))], terms), pages, nil
+}
+
+// rankHits sorts hits by score, best first, breaking ties by kind and ref.
+func rankHits(hits []SearchHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Kind != hits[j].Kind {
+			return hits[i].Kind < hits[j].Kind
+		}
+		return hits[i].Ref < hits[j].Ref
+	})
+}
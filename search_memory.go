@@ -0,0 +1,155 @@
+package main
+
+import "sync"
+
+// memorySearchIndex is an in-process inverted index. It is used with SQLite
+// and the memory backend, and is rebuilt from the repositories at startup.
+type memorySearchIndex struct {
+	mu   sync.RWMutex
+	docs map[string]*indexedDocument
+	// postings maps a token to the documents containing it and the
+	// token's positions in each.
+	postings map[string]map[string][]int
+}
+
+type indexedDocument struct {
+	doc SearchDocument
+	// titleLen is the number of title tokens; body positions start after
+	// them, leaving a gap so phrases never span title and body.
+	titleLen int
+	tokens   []string
+}
+
+// titleWeight is added to a document's score for every match in its title.
+const titleWeight = 2
+
+func newMemorySearchIndex() *memorySearchIndex {
+	return &memorySearchIndex{
+		docs:     make(map[string]*indexedDocument),
+		postings: make(map[string]map[string][]int),
+	}
+}
+
+func searchKey(kind, ref string) string {
+	return kind + ":" + ref
+}
+
+func (x *memorySearchIndex) Index(doc SearchDocument) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	key := searchKey(doc.Kind, doc.Ref)
+	x.remove(key)
+
+	title := searchTokens(doc.Title)
+	tokens := append(append(title, ""), searchTokens(doc.Body)...)
+	for pos, token := range tokens {
+		if token == "" {
+			continue
+		}
+		if x.postings[token] == nil {
+			x.postings[token] = make(map[string][]int)
+		}
+		x.postings[token][key] = append(x.postings[token][key], pos)
+	}
+	x.docs[key] = &indexedDocument{doc: doc, titleLen: len(title), tokens: tokens}
+	return nil
+}
+
+func (x *memorySearchIndex) Remove(kind, ref string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(searchKey(kind, ref))
+	return nil
+}
+
+func (x *memorySearchIndex) remove(key string) {
+	d, ok := x.docs[key]
+	if !ok {
+		return
+	}
+	for _, token := range d.tokens {
+		if docs := x.postings[token]; docs != nil {
+			delete(docs, key)
+			if len(docs) == 0 {
+				delete(x.postings, token)
+			}
+		}
+	}
+	delete(x.docs, key)
+}
+
+// Search returns documents matching every term of the query.
+func (x *memorySearchIndex) Search(q SearchQuery) ([]SearchHit, int, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if len(q.Terms) == 0 {
+		return nil, 0, nil
+	}
+
+	var hits []SearchHit
+	for key := range x.postings[q.Terms[0][0]] {
+		d := x.docs[key]
+		if !q.canSee(d.doc) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range q.Terms {
+			matches := x.termPositions(key, term)
+			if len(matches) == 0 {
+				score = 0
+				break
+			}
+			for _, pos := range matches {
+				score++
+				if pos < d.titleLen {
+					score += titleWeight
+				}
+			}
+		}
+		if score > 0 {
+			hits = append(hits, SearchHit{SearchDocument: d.doc, Score: score})
+		}
+	}
+	rankHits(hits)
+
+	total := len(hits)
+	start, end := q.Offset, q.Offset+q.Limit
+	if start > total {
+		start = total
+	}
+	if end > total || q.Limit <= 0 {
+		end = total
+	}
+	return hits[start:end], total, nil
+}
+
+// termPositions returns the positions in the document where the term, a
+// word or a phrase, starts.
+func (x *memorySearchIndex) termPositions(key string, term searchTerm) []int {
+	var starts []int
+	for _, pos := range x.postings[term[0]][key] {
+		if x.phraseAt(key, term, pos) {
+			starts = append(starts, pos)
+		}
+	}
+	return starts
+}
+
+func (x *memorySearchIndex) phraseAt(key string, term searchTerm, pos int) bool {
+	for i := 1; i < len(term); i++ {
+		found := false
+		for _, p := range x.postings[term[i]][key] {
+			if p == pos+i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
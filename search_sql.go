@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// mysqlSearcher keeps documents in the search_documents table and queries
+// its FULLTEXT index in boolean mode. The index covers a folded copy of the
+// title and body, so queries, which are folded the same way, match what the
+// in-process index matches. Note that InnoDB ignores words shorter than
+// innodb_ft_min_token_size and its stopwords.
+type mysqlSearcher struct {
+	db *sql.DB
+}
+
+func (m *mysqlSearcher) Index(doc SearchDocument) error {
+	_, err := m.db.Exec(
+		"REPLACE INTO search_documents (kind, ref, title, body, folded_title, folded_body, url, access, owner_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		doc.Kind, doc.Ref, doc.Title, doc.Body, foldSearchText(doc.Title), foldSearchText(doc.Body), doc.URL, doc.Access, doc.OwnerID,
+	)
+	return err
+}
+
+func (m *mysqlSearcher) Remove(kind, ref string) error {
+	_, err := m.db.Exec("DELETE FROM search_documents WHERE kind = ? AND ref = ?", kind, ref)
+	return err
+}
+
+func (m *mysqlSearcher) Search(q SearchQuery) ([]SearchHit, int, error) {
+	if len(q.Terms) == 0 {
+		return nil, 0, nil
+	}
+	match := booleanModeQuery(q.Terms)
+
+	// Limit the documents to those the user may see
+	where := []string{"MATCH (folded_title, folded_body) AGAINST (? IN BOOLEAN MODE)"}
+	args := []interface{}{match}
+	access := []string{"access = ''"}
+	if q.UserID != 0 {
+		access = append(access, "(owner_id <> 0 AND owner_id = ?)")
+		args = append(args, q.UserID)
+	}
+	if len(q.Permissions) > 0 {
+		access = append(access, "access IN ("+placeholders(len(q.Permissions))+")")
+		for _, perm := range q.Permissions {
+			args = append(args, perm)
+		}
+	}
+	where = append(where, "("+strings.Join(access, " OR ")+")")
+	if q.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, q.Kind)
+	}
+	cond := strings.Join(where, " AND ")
+
+	var total int
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM search_documents WHERE "+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := m.db.Query(
+		"SELECT kind, ref, title, body, url, access, owner_id, MATCH (folded_title, folded_body) AGAINST (? IN BOOLEAN MODE) AS score"+
+			" FROM search_documents WHERE "+cond+" ORDER BY score DESC, kind, ref LIMIT ? OFFSET ?",
+		append(append([]interface{}{match}, args...), q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.Kind, &h.Ref, &h.Title, &h.Body, &h.URL, &h.Access, &h.OwnerID, &h.Score); err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, total, rows.Err()
+}
+
+// booleanModeQuery requires every term, quoting phrases. Terms only contain
+// letters and digits, so no operator can be injected.
+func booleanModeQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		if len(term) == 1 {
+			parts[i] = "+" + term[0]
+		} else {
+			parts[i] = `+"` + strings.Join(term, " ") + `"`
+		}
+	}
+	return strings.Join(parts, " ")
+}
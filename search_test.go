@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var searchTestDocs = []SearchDocument{
+	{Kind: SearchPost, Ref: "1", Title: "Crème brûlée recipe", Body: "Caramelised sugar over custard."},
+	{Kind: SearchPost, Ref: "2", Title: "Привет из Москвы", Body: "Письмо о погоде и новостях."},
+	{Kind: SearchPost, Ref: "3", Title: "Καλημέρα Αθήνα", Body: "Ένα γράμμα από την Ελλάδα."},
+	{Kind: SearchPost, Ref: "4", Title: "Weather report", Body: "Sunny with a chance of custard."},
+}
+
+var searchTestCases = []struct {
+	query string
+	want  []string
+}{
+	{"creme", []string{"1"}},
+	{"crème brûlée", []string{"1"}},
+	{"custard", []string{"1", "4"}},
+	{"Москвы", []string{"2"}},
+	{"moskvy", []string{"2"}},
+	{`"письмо о погоде"`, []string{"2"}},
+	{"Αθήνα", []string{"3"}},
+	{"athina", []string{"3"}},
+	{"γράμμα", []string{"3"}},
+	{"missing", nil},
+}
+
+// testSearcher runs the shared queries against a searcher holding
+// searchTestDocs, so every backend is held to the same results.
+func testSearcher(t *testing.T, x Searcher) {
+	t.Helper()
+	for _, doc := range searchTestDocs {
+		if err := x.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range searchTestCases {
+		hits, total, err := x.Search(SearchQuery{Terms: parseSearchQuery(tt.query), Limit: 10})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		var got []string
+		for _, h := range hits {
+			got = append(got, h.Ref)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) || total != len(tt.want) {
+			t.Errorf("%q found %v (total %d), want %v", tt.query, got, total, tt.want)
+		}
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	testSearcher(t, newMemorySearchIndex())
+}
+
+// TestMySQLSearch needs a scratch MySQL database, given as a DSN such as
+// "root:secret@/weblat_test?parseTime=true" in MYSQL_TEST_DSN. Its
+// innodb_ft_min_token_size must be 1 for the short words of the queries.
+func TestMySQLSearch(t *testing.T) {
+	dsn := os.Getenv("MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_TEST_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := newMigrator(db, mysqlDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM search_documents"); err != nil {
+		t.Fatal(err)
+	}
+	testSearcher(t, &mysqlSearcher{db: db})
+}
+
+// TestBooleanModeQueryMatchesFoldedText checks, without a database, that
+// every word MySQL is asked for appears in the folded text it indexes.
+func TestBooleanModeQueryMatchesFoldedText(t *testing.T) {
+	for _, tt := range searchTestCases {
+		for _, ref := range tt.want {
+			var doc SearchDocument
+			for _, d := range searchTestDocs {
+				if d.Ref == ref {
+					doc = d
+				}
+			}
+			indexed := " " + foldSearchText(doc.Title) + " " + foldSearchText(doc.Body) + " "
+			for _, part := range strings.Split(booleanModeQuery(parseSearchQuery(tt.query)), "+") {
+				word := strings.Trim(strings.TrimSpace(part), `"`)
+				if word != "" && !strings.Contains(indexed, " "+word+" ") {
+					t.Errorf("%q: %q is not in the indexed text %q", tt.query, word, indexed)
+				}
+			}
+		}
+	}
+}
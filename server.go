@@ -0,0 +1,115 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Server holds the dependencies shared by the HTTP handlers.
+type Server struct {
+	posts     PostRepository
+	revisions RevisionRepository
+	taxonomy  TaxonomyRepository
+	users     UserRepository
+	gallery   GalleryRepository
+	contacts  ContactRepository
+	sessions  SessionStore
+	search    Searcher
+	perms     *permissionCache
+	renderer  *contentRenderer
+	tpl       *template.Template
+
+	// maxUploadBytes is the largest file accepted for upload.
+	maxUploadBytes int64
+	// images makes the resized copies of uploaded images.
+	images *imageProcessor
+	// media stores the uploaded files.
+	media BlobStore
+	// trashPeriod is how long deleted images stay in the trash.
+	trashPeriod time.Duration
+
+	// mailer delivers the mail taken from the outbox.
+	mailer Mailer
+	outbox OutboxRepository
+	// mailFrom is the sender of the application's mail.
+	mailFrom string
+	// mailMaxAttempts is how many times a message is tried.
+	mailMaxAttempts int
+	// outboxWake asks the outbox sender to run now.
+	outboxWake chan struct{}
+
+	// spam screens the public forms; spamReports keeps what it rejects.
+	spam        *spamGuard
+	spamReports SpamRepository
+}
+
+// NewServer wires the handlers to the given repositories and loads the
+// role permission bundles.
+func NewServer(repos *Repositories, tpl *template.Template) (*Server, error) {
+	perms := newPermissionCache(repos.Roles)
+	if err := perms.seedDefaults(); err != nil {
+		return nil, err
+	}
+	if err := perms.load(); err != nil {
+		return nil, err
+	}
+
+	images, err := newImageProcessor()
+	if err != nil {
+		return nil, err
+	}
+	media, err := newBlobStore()
+	if err != nil {
+		return nil, err
+	}
+	mailer, err := newMailer()
+	if err != nil {
+		return nil, err
+	}
+	spam, err := newSpamGuard()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		posts:     repos.Posts,
+		revisions: repos.Revisions,
+		taxonomy:  repos.Taxonomy,
+		users:     repos.Users,
+		gallery:   repos.Gallery,
+		contacts:  repos.Contacts,
+		sessions:  repos.Sessions,
+		search:    repos.Search,
+		perms:     perms,
+		renderer:  newContentRenderer(),
+		tpl:       tpl,
+
+		maxUploadBytes: maxUploadBytes(),
+		images:         images,
+		media:          media,
+		trashPeriod:    mediaTrashPeriod(),
+
+		mailer:          mailer,
+		outbox:          repos.Outbox,
+		mailFrom:        getEnv("MAIL_FROM", defaultMailFrom),
+		mailMaxAttempts: mailMaxAttempts(),
+		outboxWake:      make(chan struct{}, 1),
+
+		spam:        spam,
+		spamReports: repos.Spam,
+	}
+
+	// Build the search index from the stored records
+	if err := s.reindexSearch(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Handler returns the routed HTTP handler for the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+	return mux
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer returns a server backed by the memory repositories, with
+// uploads in a temporary directory and mail kept in memory.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	t.Setenv("MEDIA_STORE", "local")
+	t.Setenv("MEDIA_DIR", t.TempDir())
+	t.Setenv("MAIL_TRANSPORT", "memory")
+	t.Setenv("SPAM_MIN_SUBMIT_TIME", "0s")
+
+	tpl := template.Must(template.ParseGlob("templates/*.html"))
+	s, err := NewServer(newMemoryRepositories(), tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// addUser stores a user with the role and returns it.
+func addUser(t *testing.T, s *Server, username, role string) *User {
+	t.Helper()
+	hash, err := hashPassword("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Name: username, Email: username + "@example.com", Username: username, Password: hash, Role: role}
+	if _, err := s.users.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// signIn starts a session for the user and returns its cookie.
+func signIn(t *testing.T, s *Server, user *User) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	if _, err := s.startSession(rec, httptest.NewRequest(http.MethodPost, "/login", nil), user); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			return c
+		}
+	}
+	t.Fatal("no session cookie")
+	return nil
+}
+
+// do sends a request through the server's routes. A nil cookie sends an
+// anonymous request; a form body is sent URL encoded.
+func do(s *Server, cookie *http.Cookie, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" && method == http.MethodPost && !strings.HasPrefix(body, "{") {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if strings.HasPrefix(body, "{") {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	return rec
+}
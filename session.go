@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "session"
+	sessionTTL        = 24 * time.Hour
+)
+
+// ErrSessionNotFound is returned when a session ID is unknown or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+type Session struct {
+	ID        string
+	UserID    int
+	Role      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// SessionStore keeps the server-side session records referenced by the
+// session cookie.
+type SessionStore interface {
+	Create(s *Session) error
+	Get(id string) (*Session, error)
+	Touch(id string, lastSeen time.Time) error
+	Delete(id string) error
+	DeleteUser(userID int) error
+	DeleteExpired(now time.Time) error
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startSession creates a session record for the user and sets the cookie.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *User) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sess := &Session{
+		ID:        id,
+		UserID:    user.ID,
+		Role:      user.Role,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionTTL),
+		LastSeen:  now,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if err := s.sessions.Create(sess); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sess.ID,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return sess, nil
+}
+
+// currentSession returns the live session for the request, refreshing its
+// last-seen time.
+func (s *Server) currentSession(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	sess, err := s.sessions.Get(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(sess.ExpiresAt) {
+		s.sessions.Delete(sess.ID)
+		return nil, ErrSessionNotFound
+	}
+	if err := s.sessions.Touch(sess.ID, now); err != nil {
+		return nil, err
+	}
+	sess.LastSeen = now
+	return sess, nil
+}
+
+// endSession revokes the server-side record and clears the cookie.
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) error {
+	var err error
+	if cookie, cerr := r.Cookie(sessionCookieName); cerr == nil && cookie.Value != "" {
+		err = s.sessions.Delete(cookie.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return err
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sqlSessionStore stores sessions in the "sessions" table.
+type sqlSessionStore struct {
+	db *sql.DB
+}
+
+func newSQLSessionStore(db *sql.DB) *sqlSessionStore {
+	return &sqlSessionStore{db: db}
+}
+
+func (m *sqlSessionStore) Create(s *Session) error {
+	_, err := m.db.Exec(
+		"INSERT INTO sessions (id, user_id, role, created_at, expires_at, last_seen, ip, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		s.ID, s.UserID, s.Role, s.CreatedAt, s.ExpiresAt, s.LastSeen, s.IP, s.UserAgent,
+	)
+	return err
+}
+
+func (m *sqlSessionStore) Get(id string) (*Session, error) {
+	row := m.db.QueryRow("SELECT id, user_id, role, created_at, expires_at, last_seen, ip, user_agent FROM sessions WHERE id = ?", id)
+
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.Role, &s.CreatedAt, &s.ExpiresAt, &s.LastSeen, &s.IP, &s.UserAgent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (m *sqlSessionStore) Touch(id string, lastSeen time.Time) error {
+	_, err := m.db.Exec("UPDATE sessions SET last_seen = ? WHERE id = ?", lastSeen, id)
+	return err
+}
+
+func (m *sqlSessionStore) Delete(id string) error {
+	_, err := m.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (m *sqlSessionStore) DeleteUser(userID int) error {
+	_, err := m.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+func (m *sqlSessionStore) DeleteExpired(now time.Time) error {
+	_, err := m.db.Exec("DELETE FROM sessions WHERE expires_at < ?", now)
+	return err
+}
+
+// memorySessionStore keeps sessions in process memory. It is meant for tests
+// and single-process development setups.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (m *memorySessionStore) Create(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *memorySessionStore) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &s, nil
+}
+
+func (m *memorySessionStore) Touch(id string, lastSeen time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.LastSeen = lastSeen
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *memorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessionStore) DeleteUser(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) DeleteExpired(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if now.After(s.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// purgeExpiredSessions periodically removes expired session records.
+func (s *Server) purgeExpiredSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.sessions.DeleteExpired(time.Now()); err != nil {
+			log.Println(err)
+		}
+	}
+}
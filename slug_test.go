@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  leading and trailing  ", "leading-and-trailing"},
+		{"Multiple   ---  separators", "multiple-separators"},
+		{"Go 1.21 released", "go-1-21-released"},
+		{"Crème brûlée", "creme-brulee"},
+		{"Straße", "strasse"},
+		{"Привет мир", "privet-mir"},
+		{"Καλημέρα", "kalimera"},
+		{"日本語 text", "text"},
+		{"!!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.in); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlugifyLength(t *testing.T) {
+	slug := slugify(strings.Repeat("word ", 40))
+	if len(slug) > maxSlugLength {
+		t.Fatalf("len = %d, want at most %d", len(slug), maxSlugLength)
+	}
+	if strings.HasSuffix(slug, "-") || strings.HasSuffix(slug, "wor") {
+		t.Errorf("slug %q is cut inside a word", slug)
+	}
+}
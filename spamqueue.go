@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SpamReport is a rejected form submission kept for review.
+type SpamReport struct {
+	ID      int
+	Form    string
+	IP      string
+	Fields  map[string]string
+	Score   int
+	Reasons []string
+	// CreatedAt is when the submission was rejected.
+	CreatedAt time.Time
+}
+
+// spamField is a submitted value on the spam queue page.
+type spamField struct {
+	Name  string
+	Value string
+}
+
+// SortedFields returns the submitted values ordered by field name.
+func (r SpamReport) SortedFields() []spamField {
+	fields := make([]spamField, 0, len(r.Fields))
+	for name, value := range r.Fields {
+		fields = append(fields, spamField{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// Releasable reports whether the submission can be let through. Rejected
+// registrations cannot: their password is never kept.
+func (r SpamReport) Releasable() bool {
+	return r.Form == SpamFormContact
+}
+
+func validSpamForm(form string) bool {
+	for _, f := range spamForms {
+		if form == f {
+			return true
+		}
+	}
+	return false
+}
+
+// spamQueueHandler lists the rejected submissions, and deletes them or lets
+// contact messages through to the inbox.
+func (s *Server) spamQueueHandler(w http.ResponseWriter, r *http.Request) {
+	form := r.FormValue("form")
+	if form != "" && !validSpamForm(form) {
+		http.Error(w, "Unknown form", http.StatusBadRequest)
+		return
+	}
+	back := "/admin/spam"
+	if form != "" {
+		back += "?form=" + form
+	}
+
+	if r.Method == http.MethodPost {
+		if r.FormValue("action") == "clear" {
+			// Empty the queue, or the part of it for one form
+			if err := s.spamReports.Clear(form); err != nil {
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+
+		// Retrieve the report
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		report, err := s.spamReports.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switch r.FormValue("action") {
+		case "delete":
+		case "release":
+			// Deliver the message to the contact inbox as if it had been
+			// accepted
+			if !report.Releasable() {
+				http.Error(w, "Only contact messages can be let through", http.StatusBadRequest)
+				return
+			}
+			entry := &ContactEntry{
+				Name:       report.Fields["name"],
+				Email:      report.Fields["email"],
+				Message:    report.Fields["message"],
+				ReceivedAt: report.CreatedAt,
+				Status:     ContactNew,
+			}
+			if err := s.contacts.Create(entry); err != nil {
+				log.Println(err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			s.indexContact(entry)
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err := s.spamReports.Delete(report.ID); err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	req, err := parsePageRequest(r.URL.Query(), spamListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve a page of rejected submissions
+	reports, pages, err := s.spamPage(r, form, req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Render the spam queue
+	tpl, err := template.ParseFiles("templates/spam.html", "templates/pagination.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Reports []SpamReport
+		Pages   *Pagination
+		Form    string
+		Forms   []string
+	}{
+		Reports: reports,
+		Pages:   pages,
+		Form:    form,
+		Forms:   spamForms,
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// staticDir holds the stylesheets, scripts and other assets served under
+// /static/.
+const staticDir = "static"
+
+// URL prefixes of the served files.
+const (
+	mediaPrefix  = "/media/"
+	staticPrefix = "/static/"
+)
+
+// Fingerprinted assets carry a content hash, as in app.3f9c2a1b.css.
+var fingerprintRe = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// mediaURL returns the address a media blob is served from.
+func mediaURL(key string) string {
+	return mediaPrefix + key
+}
+
+// mediaHandler serves the uploaded files from the media store. The files
+// of images in the trash, resized copies included, are not found except
+// by the users who may empty the trash, and only they may cache them.
+// Uploads are revalidated on every use rather than cached for long, so a
+// trashed file is not kept around by shared caches.
+func (s *Server) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, mediaPrefix)
+	cache := revalidatedCache
+
+	// Look up the image the file belongs to
+	img, err := s.gallery.GetByKey(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err == nil && img.DeletedAt != nil {
+		if !s.perms.mayHave(s.apiReader(r), PermGalleryDelete) {
+			http.NotFound(w, r)
+			return
+		}
+		cache = privateCache
+	}
+	serveBlob(w, r, s.media, key, cache)
+}
+
+// staticHandler serves the static assets.
+func (s *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, staticPrefix)
+	cache := revalidatedCache
+	if fingerprintRe.MatchString(key) {
+		cache = immutableCache
+	}
+	serveBlob(w, r, &localBlobStore{root: staticDir}, key, cache)
+}
+
+// Cache-Control values of the served files. Fingerprinted assets may be
+// cached for a year; the others are revalidated with their ETag on every
+// use.
+const (
+	immutableCache   = "public, max-age=31536000, immutable"
+	revalidatedCache = "no-cache"
+	privateCache     = "private, no-cache"
+)
+
+// serveBlob serves a blob from the store with the given Cache-Control.
+// Invalid keys, hidden files and directories are not found. Range and
+// conditional requests are handled by http.ServeContent.
+func serveBlob(w http.ResponseWriter, r *http.Request, store BlobStore, key string, cache string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validBlobKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, info, err := store.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	h := w.Header()
+	if info.ContentType != "" {
+		h.Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		h.Set("ETag", info.ETag)
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", cache)
+	http.ServeContent(w, r, path.Base(key), info.ModTime, content)
+}
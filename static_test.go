@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMediaHidesTrashedImages(t *testing.T) {
+	s := newTestServer(t)
+	original := "0123456789abcdef0123456789abcdef.png"
+	thumb := "0123456789abcdef0123456789abcdef-thumb.png"
+	for _, key := range []string{original, thumb} {
+		if err := s.media.Put(key, strings.NewReader("png"), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	img := &Image{Filename: "a.png", Path: original, MIMEType: "image/png"}
+	if _, err := s.gallery.Add(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.gallery.SetDerivatives(img.ID, []Derivative{{Name: "thumb", Path: thumb, MIMEType: "image/png"}}); err != nil {
+		t.Fatal(err)
+	}
+	editor := signIn(t, s, addUser(t, s, "editor", RoleEditor))
+	author := signIn(t, s, addUser(t, s, "author", RoleAuthor))
+
+	check := func(cookie *http.Cookie, key string, wantCode int, wantCache string) {
+		t.Helper()
+		rec := do(s, cookie, http.MethodGet, mediaURL(key), "", nil)
+		if rec.Code != wantCode {
+			t.Errorf("%s: code = %d, want %d", key, rec.Code, wantCode)
+		}
+		if got := rec.Header().Get("Cache-Control"); wantCache != "" && got != wantCache {
+			t.Errorf("%s: Cache-Control = %q, want %q", key, got, wantCache)
+		}
+	}
+	check(nil, original, http.StatusOK, revalidatedCache)
+	check(nil, thumb, http.StatusOK, revalidatedCache)
+
+	if err := s.gallery.Trash(img.ID, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{original, thumb} {
+		check(nil, key, http.StatusNotFound, "")
+		check(author, key, http.StatusNotFound, "")
+		check(editor, key, http.StatusOK, privateCache)
+	}
+
+	if err := s.gallery.Restore(img.ID); err != nil {
+		t.Fatal(err)
+	}
+	check(nil, thumb, http.StatusOK, revalidatedCache)
+}
+
+func TestEditingTrashedImageKeepsItOutOfSearch(t *testing.T) {
+	s := newTestServer(t)
+	editor := signIn(t, s, addUser(t, s, "editor", RoleEditor))
+	img := &Image{Filename: "a.png", Path: "0123456789abcdef0123456789abcdef.png", MIMEType: "image/png", CreatedAt: time.Now().UTC()}
+	if _, err := s.gallery.Add(img); err != nil {
+		t.Fatal(err)
+	}
+	s.indexImage(img)
+
+	id := strconv.Itoa(img.ID)
+	if rec := do(s, editor, http.MethodPost, "/galery/delete", "id="+id, nil); rec.Code != http.StatusSeeOther {
+		t.Fatalf("delete: code = %d", rec.Code)
+	}
+	if rec := do(s, editor, http.MethodPost, "/galery/update", "id="+id+"&alt_text=unicorn", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("update of a trashed image: code = %d, want 404", rec.Code)
+	}
+
+	hits, _, err := s.search.Search(SearchQuery{Terms: parseSearchQuery("unicorn"), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Errorf("search found the trashed image: %+v", hits)
+	}
+	if got, _ := s.gallery.Get(img.ID); got.AltText != "" {
+		t.Errorf("alt text saved on a trashed image: %q", got.AltText)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openStorage returns the repositories for the named driver (mysql, sqlite
+// or memory) and a function releasing its resources. Pending migrations are
+// applied first when MIGRATE_ON_START is "true".
+func openStorage(driver string) (*Repositories, func() error, error) {
+	if driver == "memory" {
+		return newMemoryRepositories(), func() error { return nil }, nil
+	}
+
+	db, d, err := openDatabase(driver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if getEnv("MIGRATE_ON_START", "false") == "true" {
+		m, err := newMigrator(db, d)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		done, err := m.Up()
+		for _, mig := range done {
+			log.Printf("applied migration %04d_%s", mig.Version, mig.Name)
+		}
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+
+	return newSQLRepositories(db, d), db.Close, nil
+}
+
+// openDatabase opens the SQL database for the named driver.
+func openDatabase(driver string) (*sql.DB, dialect, error) {
+	var (
+		db  *sql.DB
+		d   dialect
+		err error
+	)
+	switch driver {
+	case "mysql":
+		// Connect to the database
+		connStr := fmt.Sprintf("%s:%s@/%s?parseTime=true", getEnv("DB_USERNAME", "root"), getEnv("DB_PASSWORD", ""), getEnv("DB_NAME", "weblat"))
+		db, err = sql.Open("mysql", connStr)
+		d = mysqlDialect{}
+	case "sqlite":
+		// Open (or create) the database file
+		connStr := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", getEnv("DB_PATH", "weblat.db"))
+		db, err = sql.Open("sqlite3", connStr)
+		d = sqliteDialect{}
+	default:
+		return nil, nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, d, nil
+}
+
+// dialect covers what differs between SQL backends.
+type dialect interface {
+	// name is also the directory holding the dialect's migrations.
+	name() string
+	// insertIgnore is the INSERT variant that skips duplicate keys.
+	insertIgnore() string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string         { return "mysql" }
+func (mysqlDialect) insertIgnore() string { return "INSERT IGNORE" }
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string         { return "sqlite" }
+func (sqliteDialect) insertIgnore() string { return "INSERT OR IGNORE" }
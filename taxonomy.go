@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Category groups posts in a tree. ParentID is zero for top-level
+// categories.
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID int    `json:"parent_id,omitempty"`
+
+	// Depth is the nesting level, set by categoryTree.
+	Depth int `json:"-"`
+}
+
+// Indent returns a prefix showing the category's depth in lists.
+func (c Category) Indent() string {
+	return strings.Repeat("— ", c.Depth)
+}
+
+// Tag is a flat label on posts.
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+var (
+	errUnknownCategory = errors.New("unknown category")
+	errCategoryCycle   = errors.New("a category cannot be moved below itself")
+	errBadPostForm     = errors.New("invalid form")
+)
+
+// categoryTree orders categories depth first, children after their parent,
+// and sets their Depth. Siblings keep their relative order.
+func categoryTree(categories []Category) []Category {
+	known := make(map[int]bool, len(categories))
+	for _, c := range categories {
+		known[c.ID] = true
+	}
+	children := make(map[int][]Category)
+	for _, c := range categories {
+		parent := c.ParentID
+		if !known[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	tree := make([]Category, 0, len(categories))
+	var walk func(parent, depth int)
+	walk = func(parent, depth int) {
+		for _, c := range children[parent] {
+			c.Depth = depth
+			tree = append(tree, c)
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return tree
+}
+
+// categoryDescendants returns id and the IDs of every category below it.
+func categoryDescendants(categories []Category, id int) []int {
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range categories {
+			if c.ParentID == ids[i] && c.ID != id {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// categoryCycle reports whether making parentID the parent of id would
+// create a loop.
+func categoryCycle(categories []Category, id, parentID int) bool {
+	parents := make(map[int]int, len(categories))
+	for _, c := range categories {
+		parents[c.ID] = c.ParentID
+	}
+	for p, steps := parentID, 0; p != 0 && steps <= len(categories); p, steps = parents[p], steps+1 {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueTermSlug returns a slug based on text that lookup does not report as
+// belonging to a term other than id.
+func uniqueTermSlug(text string, id int, lookup func(slug string) (int, error)) (string, error) {
+	base := slugify(text)
+	if base == "" {
+		base = "term"
+	}
+
+	for n := 1; n < 1000; n++ {
+		slug := base
+		if n > 1 {
+			suffix := fmt.Sprintf("-%d", n)
+			slug = strings.TrimRight(truncate(base, maxSlugLength-len(suffix)), "-") + suffix
+		}
+
+		owner, err := lookup(slug)
+		if errors.Is(err, ErrNotFound) || (err == nil && owner == id) {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free slug for %q", base)
+}
+
+func (s *Server) categorySlugOwner(slug string) (int, error) {
+	c, err := s.taxonomy.GetCategoryBySlug(slug)
+	if err != nil {
+		return 0, err
+	}
+	return c.ID, nil
+}
+
+func (s *Server) tagSlugOwner(slug string) (int, error) {
+	t, err := s.taxonomy.GetTagBySlug(slug)
+	if err != nil {
+		return 0, err
+	}
+	return t.ID, nil
+}
+
+// parseTagNames splits a comma separated list of tag names.
+func parseTagNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// joinTagNames is the inverse of parseTagNames.
+func joinTagNames(tags []Tag) string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// setPostTerms assigns the given categories and tags to the post. Unknown
+// category IDs are rejected; tags are created on first use.
+func (s *Server) setPostTerms(postID int, categoryIDs []int, tagNames []string) error {
+	catIDs, err := s.checkCategories(categoryIDs)
+	if err != nil {
+		return err
+	}
+
+	var tagIDs []int
+	for _, name := range tagNames {
+		slug := slugify(name)
+		if slug == "" {
+			continue
+		}
+		tag, err := s.taxonomy.GetTagBySlug(slug)
+		if errors.Is(err, ErrNotFound) {
+			tag = &Tag{Name: name, Slug: slug}
+			_, err = s.taxonomy.CreateTag(tag)
+		}
+		if err != nil {
+			return err
+		}
+		if !containsID(tagIDs, tag.ID) {
+			tagIDs = append(tagIDs, tag.ID)
+		}
+	}
+
+	if err := s.taxonomy.SetPostCategories(postID, catIDs); err != nil {
+		return err
+	}
+	return s.taxonomy.SetPostTags(postID, tagIDs)
+}
+
+// checkCategories returns the distinct category IDs, or errUnknownCategory
+// if one of them does not exist.
+func (s *Server) checkCategories(categoryIDs []int) ([]int, error) {
+	categories, err := s.taxonomy.Categories()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, id := range categoryIDs {
+		if findCategory(categories, id) == nil {
+			return nil, errUnknownCategory
+		}
+		if !containsID(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// postTermsFromRequest reads and checks the category checkboxes and the tag
+// list of the post forms.
+func (s *Server) postTermsFromRequest(r *http.Request) ([]int, []string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, errBadPostForm
+	}
+	var categoryIDs []int
+	for _, v := range r.Form["category"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, errUnknownCategory
+		}
+		categoryIDs = append(categoryIDs, id)
+	}
+	categoryIDs, err := s.checkCategories(categoryIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return categoryIDs, parseTagNames(r.Form.Get("tags")), nil
+}
+
+func (s *Server) postTermsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnknownCategory):
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+	case errors.Is(err, errBadPostForm):
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+	default:
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// loadPostTerms fills in the categories and tags of each post.
+func (s *Server) loadPostTerms(posts ...*Post) error {
+	for _, post := range posts {
+		var err error
+		if post.Categories, err = s.taxonomy.PostCategories(post.ID); err != nil {
+			return err
+		}
+		if post.Tags, err = s.taxonomy.PostTags(post.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) adminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.taxonomy.Categories()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// Retrieve the form data
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		parentID, _ := strconv.Atoi(r.FormValue("parent_id"))
+		name := strings.TrimSpace(r.FormValue("name"))
+		if r.FormValue("action") == "create" {
+			id = 0
+		}
+
+		switch r.FormValue("action") {
+		case "delete":
+			err = s.taxonomy.DeleteCategory(id)
+		case "create", "update":
+			if name == "" {
+				http.Error(w, "Name is required", http.StatusBadRequest)
+				return
+			}
+			if parentID != 0 && findCategory(categories, parentID) == nil {
+				http.Error(w, "Unknown parent category", http.StatusBadRequest)
+				return
+			}
+			if id != 0 && categoryCycle(categories, id, parentID) {
+				http.Error(w, errCategoryCycle.Error(), http.StatusBadRequest)
+				return
+			}
+
+			c := &Category{ID: id, Name: name, ParentID: parentID}
+			slug := r.FormValue("slug")
+			if slug == "" {
+				slug = name
+			}
+			c.Slug, err = uniqueTermSlug(slug, id, s.categorySlugOwner)
+			if err == nil {
+				if id == 0 {
+					_, err = s.taxonomy.CreateCategory(c)
+				} else {
+					err = s.taxonomy.UpdateCategory(c)
+				}
+			}
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+		return
+	}
+
+	// Render the category management page
+	tpl, err := template.ParseFiles("templates/categories.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, categoryTree(categories))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func findCategory(categories []Category, id int) *Category {
+	for i := range categories {
+		if categories[i].ID == id {
+			return &categories[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) adminTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// Retrieve the form data
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		name := strings.TrimSpace(r.FormValue("name"))
+		if r.FormValue("action") == "create" {
+			id = 0
+		}
+
+		var err error
+		switch r.FormValue("action") {
+		case "delete":
+			err = s.taxonomy.DeleteTag(id)
+		case "create", "update":
+			if name == "" {
+				http.Error(w, "Name is required", http.StatusBadRequest)
+				return
+			}
+
+			t := &Tag{ID: id, Name: name}
+			slug := r.FormValue("slug")
+			if slug == "" {
+				slug = name
+			}
+			t.Slug, err = uniqueTermSlug(slug, id, s.tagSlugOwner)
+			if err == nil {
+				if id == 0 {
+					_, err = s.taxonomy.CreateTag(t)
+				} else {
+					err = s.taxonomy.UpdateTag(t)
+				}
+			}
+		default:
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/admin/tags", http.StatusSeeOther)
+		return
+	}
+
+	tags, err := s.taxonomy.Tags()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Render the tag management page
+	tpl, err := template.ParseFiles("templates/tags.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, tags)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// archivePageData is rendered by archive.html.
+type archivePageData struct {
+	Kind  string
+	Name  string
+	Posts []*Post
+	Pages *Pagination
+}
+
+func (s *Server) categoryArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	// Look up the category and everything below it
+	category, err := s.taxonomy.GetCategoryBySlug(strings.TrimPrefix(r.URL.Path, "/category/"))
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+	categories, err := s.taxonomy.Categories()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	filter := PostFilter{Status: PostPublished, CategoryIDs: categoryDescendants(categories, category.ID)}
+	s.renderArchive(w, r, archivePageData{Kind: "Category", Name: category.Name}, filter)
+}
+
+func (s *Server) tagArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := s.taxonomy.GetTagBySlug(strings.TrimPrefix(r.URL.Path, "/tag/"))
+	if err != nil {
+		s.postLookupError(w, err)
+		return
+	}
+
+	filter := PostFilter{Status: PostPublished, Tag: tag.Slug}
+	s.renderArchive(w, r, archivePageData{Kind: "Tag", Name: tag.Name}, filter)
+}
+
+func (s *Server) renderArchive(w http.ResponseWriter, r *http.Request, data archivePageData, filter PostFilter) {
+	req, err := parsePageRequest(r.URL.Query(), postListing)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Retrieve a page of the matching published posts
+	posts, pages, err := s.postPage(r, filter, req)
+	if err == nil {
+		err = s.loadPostTerms(posts...)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.renderPosts(posts...)
+	data.Posts = posts
+	data.Pages = pages
+
+	// Render the archive
+	tpl, err := template.ParseFiles("templates/archive.html", "templates/pagination.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// uploadDir is where uploaded files are stored when MEDIA_DIR is not set.
+const uploadDir = "uploads"
+
+// defaultMaxUploadBytes is the largest file accepted when UPLOAD_MAX_BYTES
+// is not set.
+const defaultMaxUploadBytes = 10 << 20
+
+// uploadFormOverhead is allowed on top of the file size for the other form
+// fields and the multipart framing.
+const uploadFormOverhead = 1 << 20
+
+// uploadTypes maps the content types accepted for upload, as sniffed from
+// the file itself, to the extension of the stored file.
+var uploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// maxUploadBytes returns the configured upload size limit.
+func maxUploadBytes() int64 {
+	v := getEnv("UPLOAD_MAX_BYTES", "")
+	if v == "" {
+		return defaultMaxUploadBytes
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("invalid UPLOAD_MAX_BYTES %q, using %d", v, defaultMaxUploadBytes)
+		return defaultMaxUploadBytes
+	}
+	return n
+}
+
+// uploadError is an upload rejected for a reason the client can fix.
+type uploadError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// storeUpload checks the uploaded file, removes its metadata and puts it in
+// the media store under a generated key. img is filled in with the stored
+// file's details, and the stored content is returned.
+func (s *Server) storeUpload(file multipart.File, header *multipart.FileHeader, img *Image) ([]byte, error) {
+	if header.Size > s.maxUploadBytes {
+		return nil, s.uploadTooLarge()
+	}
+	if err := readImageInfo(file, img); err != nil {
+		return nil, err
+	}
+	if img.Size > s.maxUploadBytes {
+		return nil, s.uploadTooLarge()
+	}
+	ext, ok := uploadTypes[img.MIMEType]
+	if !ok {
+		return nil, &uploadError{http.StatusUnsupportedMediaType, "unsupported_type", "Only JPEG, PNG, GIF and WebP images can be uploaded"}
+	}
+	if img.Width*img.Height > maxImagePixels {
+		return nil, &uploadError{http.StatusRequestEntityTooLarge, "too_large", "The image has too many pixels"}
+	}
+	img.Filename = uploadFilename(header.Filename)
+
+	// Remove the metadata and describe the file that is kept
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err = prepareOriginal(data, img.MIMEType)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "invalid_image", "The image could not be read"}
+	}
+	if err := readImageInfo(bytes.NewReader(data), img); err != nil {
+		return nil, err
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+	img.Path = name + ext
+	if err := s.media.Put(img.Path, bytes.NewReader(data), img.MIMEType); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Server) uploadTooLarge() error {
+	return &uploadError{http.StatusRequestEntityTooLarge, "too_large", fmt.Sprintf("The file is larger than %s", Image{Size: s.maxUploadBytes}.HumanSize())}
+}
+
+// randomName returns a name for a stored file that cannot collide with
+// another or be chosen by the client.
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// uploadFilename cleans the client's filename for display. It is never
+// used to build a path.
+func uploadFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" {
+		return "upload"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	return name
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeUploadError answers a failed upload in the format the client asked
+// for. Errors other than *uploadError are logged and reported as a 500.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var uerr *uploadError
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &uerr):
+	case errors.As(err, &maxErr):
+		uerr = &uploadError{http.StatusRequestEntityTooLarge, "too_large", "The upload is too large"}
+	default:
+		log.Println(err)
+		uerr = &uploadError{http.StatusInternalServerError, "internal", "Internal Server Error"}
+	}
+
+	if wantsJSON(r) {
+		writeJSONError(w, uerr.status, uerr.code, uerr.message)
+		return
+	}
+	http.Error(w, uerr.message, uerr.status)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a problem with one submitted field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors lists the problems found in a submission, at most one
+// per field, in the order the fields were checked.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Has reports whether the field has an error.
+func (e ValidationErrors) Has(field string) bool {
+	return e.For(field) != ""
+}
+
+// For returns the field's error message, or "".
+func (e ValidationErrors) For(field string) string {
+	for _, fe := range e {
+		if fe.Field == field {
+			return fe.Message
+		}
+	}
+	return ""
+}
+
+// validator checks submitted values. Every rule records an error for its
+// field unless the field already has one, so each field reports the first
+// rule it breaks.
+type validator struct {
+	errs ValidationErrors
+}
+
+// Valid reports whether every rule passed.
+func (v *validator) Valid() bool {
+	return len(v.errs) == 0
+}
+
+// Errors returns the errors found so far.
+func (v *validator) Errors() ValidationErrors {
+	return v.errs
+}
+
+// Check records message for the field unless ok. It is the base of the
+// other rules and serves for custom ones.
+func (v *validator) Check(ok bool, field, message string) {
+	if !ok && !v.errs.Has(field) {
+		v.errs = append(v.errs, FieldError{Field: field, Message: message})
+	}
+}
+
+// Required checks the value is not blank.
+func (v *validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "This field is required.")
+}
+
+// MinLength checks the value has at least min characters.
+func (v *validator) MinLength(field, value string, min int) {
+	v.Check(utf8.RuneCountInString(value) >= min, field, fmt.Sprintf("Use at least %d characters.", min))
+}
+
+// MaxLength checks the value has at most max characters.
+func (v *validator) MaxLength(field, value string, max int) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("Use at most %d characters.", max))
+}
+
+// Email checks the value is a bare email address. Empty values pass; use
+// Required as well for mandatory fields.
+func (v *validator) Email(field, value string) {
+	if value == "" {
+		return
+	}
+	addr, err := mail.ParseAddress(value)
+	v.Check(err == nil && addr.Address == value, field, "Enter a valid email address.")
+}
+
+// Matches checks the value matches the pattern. Empty values pass.
+func (v *validator) Matches(field, value string, re *regexp.Regexp, message string) {
+	if value == "" {
+		return
+	}
+	v.Check(re.MatchString(value), field, message)
+}
+
+// Unique checks that no other record holds the value, using taken to look
+// it up. The lookup is skipped when the field already has an error.
+func (v *validator) Unique(field string, taken func() (bool, error), message string) error {
+	if v.errs.Has(field) {
+		return nil
+	}
+	exists, err := taken()
+	if err != nil {
+		return err
+	}
+	v.Check(!exists, field, message)
+	return nil
+}
+
+// formState is what a form is rendered with again after a failed
+// submission: the values sent, to fill the fields in, and the errors to
+// show next to them.
+type formState struct {
+	Values url.Values
+	Errors ValidationErrors
+}
+
+// writeValidationErrors answers a JSON client with the field errors.
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: apiErrorBody{
+		Code:    "validation_failed",
+		Message: "The submission has errors",
+		Fields:  errs,
+	}})
+}
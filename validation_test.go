@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	pattern := regexp.MustCompile(`^[a-z]+$`)
+	tests := []struct {
+		name  string
+		check func(v *validator)
+		want  ValidationErrors
+	}{
+		{"required", func(v *validator) { v.Required("f", "  ") }, ValidationErrors{{"f", "This field is required."}}},
+		{"present", func(v *validator) { v.Required("f", "x") }, nil},
+		{"min length counts characters", func(v *validator) { v.MinLength("f", "ééé", 3) }, nil},
+		{"too short", func(v *validator) { v.MinLength("f", "ab", 3) }, ValidationErrors{{"f", "Use at least 3 characters."}}},
+		{"too long", func(v *validator) { v.MaxLength("f", "abcd", 3) }, ValidationErrors{{"f", "Use at most 3 characters."}}},
+		{"email", func(v *validator) { v.Email("f", "a@example.com") }, nil},
+		{"email with name", func(v *validator) { v.Email("f", "A <a@example.com>") }, ValidationErrors{{"f", "Enter a valid email address."}}},
+		{"bad email", func(v *validator) { v.Email("f", "nope") }, ValidationErrors{{"f", "Enter a valid email address."}}},
+		{"empty email passes", func(v *validator) { v.Email("f", "") }, nil},
+		{"pattern", func(v *validator) { v.Matches("f", "ABC", pattern, "lower case") }, ValidationErrors{{"f", "lower case"}}},
+		{"first error wins", func(v *validator) {
+			v.Required("f", "")
+			v.MinLength("f", "", 3)
+		}, ValidationErrors{{"f", "This field is required."}}},
+		{"fields in order", func(v *validator) {
+			v.Required("b", "")
+			v.Required("a", "")
+		}, ValidationErrors{{"b", "This field is required."}, {"a", "This field is required."}}},
+	}
+	for _, tt := range tests {
+		v := &validator{}
+		tt.check(v)
+		if !reflect.DeepEqual(v.Errors(), tt.want) {
+			t.Errorf("%s: errors = %v, want %v", tt.name, v.Errors(), tt.want)
+		}
+		if v.Valid() != (len(tt.want) == 0) {
+			t.Errorf("%s: Valid() = %v", tt.name, v.Valid())
+		}
+	}
+}
+
+func TestValidatorUnique(t *testing.T) {
+	v := &validator{}
+	if err := v.Unique("u", func() (bool, error) { return true, nil }, "taken"); err != nil {
+		t.Fatal(err)
+	}
+	if v.Errors().For("u") != "taken" {
+		t.Errorf("errors = %v", v.Errors())
+	}
+
+	// The lookup is skipped once the field has an error
+	if err := v.Unique("u", func() (bool, error) { t.Error("looked up"); return false, nil }, "taken"); err != nil {
+		t.Fatal(err)
+	}
+
+	boom := errors.New("boom")
+	if err := (&validator{}).Unique("u", func() (bool, error) { return false, boom }, "taken"); err != boom {
+		t.Errorf("err = %v, want %v", err, boom)
+	}
+}
+
+func TestValidatePost(t *testing.T) {
+	tests := []struct {
+		title, content, format string
+		want                   []string
+	}{
+		{"Title", "Body", FormatMarkdown, nil},
+		{"", "", FormatMarkdown, []string{"title", "content"}},
+		{strings.Repeat("t", maxPostTitleLength+1), "Body", FormatHTML, []string{"title"}},
+		{"Title", "Body", "rtf", []string{"format"}},
+	}
+	for _, tt := range tests {
+		v := &validator{}
+		validatePost(v, tt.title, tt.content, tt.format)
+		var got []string
+		for _, fe := range v.Errors() {
+			got = append(got, fe.Field)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("validatePost(%.10q, %q, %q) fields = %v, want %v", tt.title, tt.content, tt.format, got, tt.want)
+		}
+	}
+}